@@ -1,5 +1,7 @@
 package ast
 
+import "time"
+
 type Program struct {
 	Defines    map[string]Expr
 	Functions  map[string]*Function
@@ -10,6 +12,14 @@ type Program struct {
 	// EventFunctions stores all functions for event names (EVENTSHOP, EVENTFIRST, etc.)
 	// These functions are called in order, not merged
 	EventFunctions map[string][]*Function
+	Stats          ProgramStats
+}
+
+// ProgramStats summarises a parse for load-time diagnostics.
+type ProgramStats struct {
+	Functions     int // every @ definition, including each event handler
+	Statements    int // statements in all bodies, nested blocks included
+	ParseDuration time.Duration
 }
 
 type VarDecl struct {
@@ -19,6 +29,10 @@ type VarDecl struct {
 	Scope     string // global|local|dynamic
 	IsRef     bool
 	IsDynamic bool
+	NoData    bool   // #DIM NODATA: excluded from SAVEGAME/SAVEDATA slots
+	Init      []Expr // "= a, b, c" initializer list, applied to leading elements
+	File      string // declaring file and line, for diagnostics
+	Line      int
 }
 
 type Function struct {
@@ -38,6 +52,22 @@ type Arg struct {
 type Thunk struct {
 	Statements []Statement
 	LabelMap   map[string]int
+	// Positions holds the source position of Statements[i] at index i.
+	// Thunks assembled outside the parser may leave it short or empty.
+	Positions []Pos
+}
+
+type Pos struct {
+	File string
+	Line int
+}
+
+// PosAt returns the recorded source position of statement i, if any.
+func (t *Thunk) PosAt(i int) (Pos, bool) {
+	if t == nil || i < 0 || i >= len(t.Positions) || t.Positions[i].File == "" {
+		return Pos{}, false
+	}
+	return t.Positions[i], true
 }
 
 type Statement interface {
@@ -143,8 +173,10 @@ type SelectCaseStmt struct {
 func (SelectCaseStmt) isStatement() {}
 
 type SelectCaseBranch struct {
-	Conditions []CaseCondition
-	Body       *Thunk
+	Conditions  []CaseCondition
+	Body        *Thunk
+	Fallthrough bool // CASE line ended with ",": continue into the next body
+	MatchAll    bool // CASE line began with "&": every condition must match
 }
 
 type CaseCondition struct {
@@ -209,6 +241,12 @@ type IntLit struct {
 
 func (IntLit) isExpr() {}
 
+type FloatLit struct {
+	Value float64
+}
+
+func (FloatLit) isExpr() {}
+
 type StringLit struct {
 	Value string
 }
@@ -24,6 +24,9 @@ func runPlain(cfg appConfig) error {
 		return fmt.Errorf("save format: %w", err)
 	}
 	vm.SetSaveDir(cfg.base)
+	vm.SetCSVSource(func() (map[string]string, error) {
+		return loadScripts(cfg.base)
+	})
 
 	reader := bufio.NewReader(os.Stdin)
 
@@ -26,6 +26,9 @@ func runVM(cfg appConfig, events chan<- tea.Msg) {
 		return
 	}
 	vm.SetSaveDir(cfg.base)
+	vm.SetCSVSource(func() (map[string]string, error) {
+		return loadScripts(cfg.base)
+	})
 
 	vm.SetOutputHook(func(out eruntime.Output) {
 		events <- vmOutputMsg{out: out}
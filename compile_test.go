@@ -1,10 +1,16 @@
 package erago_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gosuda/erago"
 	"github.com/gosuda/erago/parser"
@@ -192,6 +198,96 @@ QUIT
 	}
 }
 
+func TestCSVEnumListsTableByID(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+CSVENUM ABL
+PRINTVL RESULT
+PRINTFORML %RESULTS:0%,%RESULTS:1%,%RESULTS:2%
+QUIT
+`,
+		"ABL.CSV": "3,Skill\n1,Strength\n2,Stamina\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	if out[0].Text != "3" {
+		t.Fatalf("unexpected CSVENUM count: %q", out[0].Text)
+	}
+	if out[1].Text != "Strength,Stamina,Skill" {
+		t.Fatalf("unexpected CSVENUM names: %q", out[1].Text)
+	}
+}
+
+func TestPrintFormFillCharacter(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 42
+PRINTFORML [%A,5,RIGHT,0%]
+PRINTFORML [%A,5,LEFT,"."%]
+PRINTFORML [%A,5,RIGHT%]
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"[00042]", "[42...]", "[   42]"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestReplaceCSVAppliesToForms(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+MONEY = 300
+PRINTFORML %MONEY%[YEN]
+PRINTL [YEN]
+QUIT
+`,
+		"_Replace.csv": "; display tokens\n[YEN],円\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	if out[0].Text != "300円" {
+		t.Fatalf("unexpected replaced form output: %q", out[0].Text)
+	}
+	if out[1].Text != "[YEN]" {
+		t.Fatalf("plain PRINT should stay verbatim: %q", out[1].Text)
+	}
+}
+
 func TestSaveLoadCommands(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERB": `
@@ -250,6 +346,41 @@ QUIT
 	}
 }
 
+func TestSubstringByteAndRuneModes(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+SUBSTRING "あいうえ", 2, 4
+PRINTVL RESULTS
+SUBSTRINGU "あいうえ", 2, 4
+PRINTVL RESULTS
+SUBSTRING "aあbい", 1, 3
+PRINTVL RESULTS
+SUBSTRING "aあbい", 2, 3
+PRINTVL RESULTS
+PRINTVL BYTESUBSTRING("あいう", 2)
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"いう", "うえ", "あb", "b", "いう"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
 func TestSelectCaseFlow(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERB": `
@@ -279,6 +410,48 @@ QUIT
 	}
 }
 
+func TestSelectCaseCSVNamedConstants(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ABL:0:2 = 1
+FOR I, 1, 4
+    SELECTCASE I
+        CASE Stamina
+            PRINTL stamina
+        CASE Strength, Skill
+            PRINTL other
+        CASEELSE
+            PRINTL none
+    ENDSELECT
+NEXT
+SELECTCASE ABL:0:Stamina
+    CASE 1
+        PRINTL indexed
+ENDSELECT
+QUIT
+`,
+		"ABL.CSV": "1,Strength\n2,Stamina\n3,Skill\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"other", "stamina", "other", "indexed"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
 func TestStrDataAndPrintDataBlock(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERB": `
@@ -537,6 +710,85 @@ QUIT
 	}
 }
 
+func TestCharacterRosterAPI(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTVL CHARANUM
+PRINTVL NO:1
+ADDCHARA 9
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetCharacters([]eruntime.RuntimeCharacter{
+		{ID: 0, Vars: map[string]eruntime.Value{}},
+		{ID: 5, Vars: map[string]eruntime.Value{"NAME": eruntime.Str("Sakuya")}},
+	})
+	if got := vm.Globals()["CHARANUM"].Int64(); got != 2 {
+		t.Fatalf("CHARANUM after SetCharacters = %d, want 2", got)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 2 || out[0].Text != "2" || out[1].Text != "5" {
+		t.Fatalf("unexpected outputs: %+v", out)
+	}
+	chars := vm.Characters()
+	if len(chars) != 3 || chars[2].ID != 9 {
+		t.Fatalf("unexpected roster after run: %+v", chars)
+	}
+	chars[1].Vars["NAME"] = eruntime.Str("changed")
+	if vm.Characters()[1].Vars["NAME"].String() != "Sakuya" {
+		t.Fatalf("Characters should return a copy")
+	}
+}
+
+func TestCopyCharaDeepCopiesData(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+CFLAG:0:5 = 7
+ADDCOPYCHARA 0
+PRINTVL RESULT
+CFLAG:1:5 = 9
+ADDCHARA 3
+CFLAG:2:1 = 4
+COPYCHARA 0, 2
+PRINTFORML {CFLAG:0:5},{CFLAG:1:5},{CFLAG:2:5},{CFLAG:2:1},{NO:2}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetCharacters([]eruntime.RuntimeCharacter{
+		{ID: 1, Vars: map[string]eruntime.Value{"MOOD": eruntime.Int(2)}},
+	})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"1", "7,9,7,0,1"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+	chars := vm.Characters()
+	if len(chars) != 3 || chars[1].Vars["MOOD"].Int64() != 2 || chars[2].Vars["MOOD"].Int64() != 2 {
+		t.Fatalf("Vars were not copied: %+v", chars)
+	}
+}
+
 func TestUtilityCommandFamilies(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERH": `
@@ -588,6 +840,75 @@ QUIT
 	}
 }
 
+func TestMaxLogLinesTrimsOutput(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+FOR I, 0, 5
+    PRINTFORML line{I}
+NEXT
+GETLINECOUNT
+X = RESULT
+CLEARLINE 10
+PRINTL after
+PRINTFORML count={LINECOUNT}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetMaxLogLines(3)
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"after", "count=3"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+	if got := vm.Globals()["X"].Int64(); got != 5 {
+		t.Fatalf("GETLINECOUNT should count trimmed lines, got %d", got)
+	}
+}
+
+func TestSysInfo(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+SYSINFO "engine"
+PRINTVL RESULTS
+PRINTFORML %SYSINFO("BUILD")%
+PRINTFORML [%SYSINFO("MISSING")%]
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSysInfo("build", "web")
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"erago", "web", "[]"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
 func TestDebugClear(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERB": `
@@ -667,6 +988,70 @@ RETURNFORM hello {1+1}
 	}
 }
 
+func TestRefBindsArrayElement(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERH": `
+#DIM ARR, 5
+#DIM REF R
+`,
+		"MAIN.ERB": `
+@TITLE
+I = 3
+REF R, ARR:I
+R = 5
+I = 0
+R += 1
+R++
+PRINTFORML {ARR:3},{ARR:0},{R}
+REFBYNAME R, "ARR:1"
+R = 9
+PRINTVL ARR:1
+FLAG:0 = 5
+REF R, FLAG:"quest"
+R = 9
+S = "quest"
+REF R, FLAG:S
+R += 1
+PRINTFORML {FLAG:0}/{FLAG:"quest"}/{R}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"7,0,7", "9", "5/10/10"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+
+	bad := map[string]string{
+		"MAIN.ERH": "#DIM REF R\n",
+		"MAIN.ERB": `
+@TITLE
+S = "quest"
+REF R, CFLAG:0:S
+QUIT
+`,
+	}
+	vm, err = erago.Compile(bad)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if _, err := vm.Run("TITLE"); err == nil || !strings.Contains(err.Error(), `no CSV entry named "quest"`) {
+		t.Fatalf("expected unresolved key error, got %v", err)
+	}
+}
+
 func TestEmueraMethodArrayAndStringHelpers(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERH": `
@@ -777,7 +1162,101 @@ QUIT
 	}
 }
 
-func TestTryCatchAndFuncEndFuncFlow(t *testing.T) {
+func TestMaxCallDepthGuard(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+CALL PING(0)
+QUIT
+
+@PING(N)
+CALL PONG(N + 1)
+
+@PONG(N)
+CALL PING(N + 1)
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetMaxCallDepth(64)
+	_, err = vm.Run("TITLE")
+	if err == nil {
+		t.Fatalf("expected call depth error")
+	}
+	if !strings.Contains(err.Error(), "call depth limit exceeded (64)") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMaxLoopIterations(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+FOR I, 0, 5
+    A += 1
+NEXT
+PRINTVL A
+WHILE 1
+    B += 1
+WEND
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetMaxLoopIterations(5)
+	out, err := vm.Run("TITLE")
+	if err == nil {
+		t.Fatalf("expected loop iteration error, got outputs %+v", out)
+	}
+	if !strings.Contains(err.Error(), "WHILE loop exceeded 5 iterations (fn=TITLE)") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := vm.Globals()["B"].Int64(); got != 5 {
+		t.Fatalf("WHILE body should run exactly 5 times, got %d", got)
+	}
+}
+
+func TestRuntimeErrorReportsSourceLine(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 1
+IF A
+    CALL HELPER
+ENDIF
+QUIT
+
+@HELPER
+PRINTL before
+FORCE_BEGIN
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	_, err = vm.Run("TITLE")
+	if err == nil {
+		t.Fatalf("expected runtime error")
+	}
+	var rerr *eruntime.RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected RuntimeError, got %T: %v", err, err)
+	}
+	if rerr.File != "MAIN.ERB" || rerr.Line != 4 || rerr.Func != "TITLE" {
+		t.Fatalf("unexpected outer position: %s:%d fn=%s", rerr.File, rerr.Line, rerr.Func)
+	}
+	if !strings.Contains(err.Error(), "MAIN.ERB:11: HELPER pc 1") {
+		t.Fatalf("error should mention the failing source line: %v", err)
+	}
+}
+
+func TestTryCatchAndFuncEndFuncFlow(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERB": `
 @TITLE
@@ -907,6 +1386,53 @@ QUIT
 	}
 }
 
+func TestTimedOneInputDefaults(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+TONEINPUT 10, 37
+A = RESULT
+TONEINPUTS 10, "yes"
+S = RESULTS
+TONEINPUT 10, 4, 0, "late"
+B = RESULT
+PRINTFORML {A},%S%,{B}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	var reqs []eruntime.InputRequest
+	vm.SetInputProvider(func(req eruntime.InputRequest) (string, bool, error) {
+		reqs = append(reqs, req)
+		if len(reqs) == 3 {
+			return "", true, nil
+		}
+		return "", false, nil
+	})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) == 0 || out[len(out)-1].Text != "3,y,4" {
+		t.Fatalf("unexpected timed defaults: %+v", out)
+	}
+	if len(reqs) != 3 {
+		t.Fatalf("unexpected request count: %d", len(reqs))
+	}
+	if !reqs[0].HasDefault || reqs[0].DefaultValue.Int64() != 3 || !reqs[0].OneInput || !reqs[0].Timed {
+		t.Fatalf("unexpected TONEINPUT request: %+v", reqs[0])
+	}
+	if !reqs[1].HasDefault || reqs[1].DefaultValue.String() != "y" {
+		t.Fatalf("unexpected TONEINPUTS request: %+v", reqs[1])
+	}
+	if reqs[2].Countdown || reqs[2].TimeoutMessage != "late" {
+		t.Fatalf("unexpected countdown/message parsing: %+v", reqs[2])
+	}
+}
+
 func TestPrintWConsumesQueuedInputBeforeInput(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERB": `
@@ -1038,6 +1564,97 @@ QUIT
 	}
 }
 
+func TestCharacterVarsLiveOnCharacters(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 5
+PRINTFORML {CFLAG:0:10},{CFLAG:0:11}
+CFLAG:0:10 = 8
+CSTR:0:2 = "note"
+SAVECHARA "party", "memo", 0
+DELALLCHARA
+LOADCHARA "party"
+PRINTFORML {CFLAG:0:10},{CFLAG:0:11},%CSTR:0:2%
+QUIT
+`,
+		"CHARA5.CSV": "番号,5\nCFLAG,10,3\nCFLAG,11,4\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"3,4", "8,4,note"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+	chars := vm.Characters()
+	if len(chars) != 1 || chars[0].Vars["CFLAG:10"].Int64() != 8 {
+		t.Fatalf("CFLAG:10 not stored on character: %+v", chars)
+	}
+}
+
+func TestCharacterVarsSurviveSaveRoundTrips(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 5
+CFLAG:0:3 = 7
+ABL:0:2 = 4
+SAVEGAME 1
+CFLAG:0:3 = 0
+ABL:0:2 = 0
+LOADGAME 1
+PRINTFORML {CFLAG:0:3},{ABL:0:2}
+SAVEVAR "party", "memo"
+CFLAG:0:3 = 0
+ABL:0:2 = 0
+LOADVAR "party"
+PRINTFORML {CFLAG:0:3},{ABL:0:2}
+SAVEVAR "one", "memo", CFLAG:0:3, ABL
+CFLAG:0:3 = 1
+ABL:0:2 = 1
+LOADVAR "one"
+PRINTFORML {CFLAG:0:3},{ABL:0:2}
+QUIT
+`,
+		"CHARA5.CSV": "番号,5\n",
+	}
+	for _, format := range []string{"json", "binary"} {
+		vm, err := erago.Compile(files)
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		vm.SetSaveDir(t.TempDir())
+		if err := vm.SetDatSaveFormat(format); err != nil {
+			t.Fatalf("set format: %v", err)
+		}
+		out, err := vm.Run("TITLE")
+		if err != nil {
+			t.Fatalf("%s: run failed: %v", format, err)
+		}
+		expect := []string{"7,4", "7,4", "7,4"}
+		if len(out) != len(expect) {
+			t.Fatalf("%s: unexpected output count: %d (%+v)", format, len(out), out)
+		}
+		for i := range expect {
+			if out[i].Text != expect[i] {
+				t.Fatalf("%s: unexpected output at %d: got=%q want=%q", format, i, out[i].Text, expect[i])
+			}
+		}
+	}
+}
+
 func TestSaveVarBinaryMode(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERH": "#DIM ARR, 3\n",
@@ -1188,6 +1805,38 @@ RETURN 0
 	}
 }
 
+func TestHTMLEscapeOutputMode(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTL <b>bold</b> & co
+PRINTFORML {1+1}<i>
+HTML_PRINT "<b>raw</b>"
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetHTMLEscapeOutput(true)
+	var hooked []string
+	vm.SetOutputHook(func(o eruntime.Output) { hooked = append(hooked, o.Text) })
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"&lt;b&gt;bold&lt;/b&gt; &amp; co", "2&lt;i&gt;", "raw"}
+	if len(out) != len(expect) || len(hooked) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp || hooked[i] != exp {
+			t.Fatalf("unexpected output at %d: got=%q hook=%q want=%q", i, out[i].Text, hooked[i], exp)
+		}
+	}
+}
+
 func TestHTMLStringFunctions(t *testing.T) {
 	files := map[string]string{
 		"MAIN.ERB": `
@@ -1324,6 +1973,48 @@ QUIT
 	}
 }
 
+func TestEnumFunctionsSortedAndUnique(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERH": `
+#DIM SHOP_B, 3
+#DIM SHOP_A
+`,
+		"MAIN.ERB": `
+@TITLE
+SHOP_C = 1
+PRINTFORML %ENUMFUNCBEGINSWITH("SHOP_")%
+PRINTFORML %ENUMVARBEGINSWITH("SHOP_")%
+QUIT
+
+@SHOP_Z
+RETURN 0
+
+@SHOP_M
+RETURN 0
+
+@SHOP_A
+RETURN 0
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	if out[0].Text != "SHOP_A:SHOP_M:SHOP_Z" {
+		t.Fatalf("unexpected function enumeration: %q", out[0].Text)
+	}
+	if out[1].Text != "SHOP_A:SHOP_B:SHOP_C" {
+		t.Fatalf("unexpected variable enumeration: %q", out[1].Text)
+	}
+}
+
 func containsString(s, substr string) bool {
 	return len(s) > 0 && (s == substr || len(s) > len(substr) && (s[:len(substr)] == substr || s[len(s)-len(substr):] == substr || containsMiddle(s, substr)))
 }
@@ -1680,3 +2371,3723 @@ ENDIF
 		t.Fatalf("expected menu output, got %v", out)
 	}
 }
+
+func TestResultSharedAcrossCalls(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+CALL PAIR
+PRINTFORML {RESULT},{RESULT:1}
+CALL SHADOW
+PRINTFORML {RESULT}
+RESULT = 9
+CALL SHOW
+CALL BYARG(4)
+PRINTFORML {RESULT}
+RESULTS = "hey"
+CALL SHOWS
+QUIT
+
+@PAIR
+RETURN 5, 6
+
+@SHADOW
+#DIM RESULT, 3
+RESULT = 42
+
+@SHOW
+PRINTFORML show={RESULT}
+
+@SHOWS
+PRINTFORML shows=%RESULTS%
+
+@BYARG(RESULT)
+PRINTFORML arg={RESULT}
+RESULT:0 = 77
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"5,6", "42", "show=9", "arg=4", "77", "shows=hey"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+	globals := vm.Globals()
+	if got := globals["RESULT"].Int64(); got != vm.Result().Int64() || got == 0 {
+		t.Fatalf("Globals RESULT = %d, want %d", got, vm.Result().Int64())
+	}
+	if got := globals["RESULTS"].String(); got != "hey" {
+		t.Fatalf("Globals RESULTS = %q, want %q", got, "hey")
+	}
+}
+
+func TestLevelTablesByName(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTFORML {GETPALAMLV(600)},{GETEXPLV(25)}
+PRINTFORML {GETPALAMLV(25, "STEEP")},{GETPALAMLV(25, "FLAT")}
+GETEXPLV 35, "STEEP"
+PRINTVL RESULT
+QUIT
+`,
+		"STEEP.CSV": "0,0\n1,10\n2,20\n3,30\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetLevelTable("flat", []int64{0, 1000})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"2,3", "2,0", "3"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestLevelMaxArgumentCapsLevel(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+X = 100000
+GETPALAMLV X, 5
+PRINTVL RESULT
+PRINTFORML {GETPALAMLV(X, 9)},{GETEXPLV(50, 2)}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"5", "7,2"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestTabWidthExpandsTabs(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTL a\tb
+TABWIDTH 4
+PRINTL a\tb
+PRINTL あ\tb
+PRINT ab
+PRINTL \tc
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"a\tb", "a   b", "あ  b", "ab", "  c"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestQuickSaveRestoresFullState(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 10
+FLAG:3 = 4
+ADDCHARA 7
+CFLAG:0:2 = 5
+SETCOLOR "ABCDEF"
+QUICKSAVE 1
+PRINTFORML {RAND:1000000}
+A = 99
+FLAG:3 = 0
+CFLAG:0:2 = 0
+ADDCHARA 8
+SETCOLOR "123456"
+QUICKLOAD 1
+PRINTVL RESULT
+PRINTFORML {RAND:1000000}
+PRINTFORML {A},{FLAG:3},{CFLAG:0:2},{CHARANUM},{NO:0}
+GETCOLOR
+PRINTVL RESULT
+QUICKLOAD 2
+PRINTVL RESULT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 6 {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	if out[0].Text != out[2].Text {
+		t.Fatalf("random sequence not restored: %q vs %q", out[0].Text, out[2].Text)
+	}
+	expect := map[int]string{1: "1", 3: "10,4,5,1,7", 4: "ABCDEF", 5: "0"}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestQuickSaveKeepsRandSequence(t *testing.T) {
+	run := func(save string) []eruntime.Output {
+		t.Helper()
+		files := map[string]string{
+			"MAIN.ERB": `
+@TITLE
+INITRAND 5
+PRINTFORML {RAND:1000000}
+` + save + `
+PRINTFORML {RAND:1000000}
+PRINTFORML {RAND:1000000}
+QUIT
+`,
+		}
+		vm, err := erago.Compile(files)
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		vm.SetSaveDir(t.TempDir())
+		out, err := vm.Run("TITLE")
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		return out
+	}
+	plain := run("")
+	saved := run("QUICKSAVE 1")
+	if len(plain) != 3 || len(saved) != 3 {
+		t.Fatalf("unexpected output: %+v / %+v", plain, saved)
+	}
+	for i := range plain {
+		if plain[i].Text != saved[i].Text {
+			t.Fatalf("QUICKSAVE changed RAND at %d: got=%q want=%q", i, saved[i].Text, plain[i].Text)
+		}
+	}
+	reloaded := run("QUICKSAVE 1\nPRINTFORML {RAND:1000000}\nQUICKLOAD 1")
+	if len(reloaded) != 4 || reloaded[1].Text != plain[1].Text || reloaded[2].Text != plain[1].Text || reloaded[3].Text != plain[2].Text {
+		t.Fatalf("QUICKLOAD did not rewind to the saved position: %+v vs %+v", reloaded, plain)
+	}
+}
+
+func TestQuickLoadRestoresEveryRandStream(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+INITRAND 5
+RANDSTREAM "side"
+INITRAND 9
+A = RAND:1000000
+RANDSTREAM ""
+B = RAND:1000000
+RANDSTREAM "side"
+QUICKSAVE 1
+PRINTFORML {RAND:1000000}
+RANDSTREAM ""
+PRINTFORML {RAND:1000000}
+QUICKLOAD 1
+PRINTFORML {RAND:1000000}
+RANDSTREAM ""
+PRINTFORML {RAND:1000000}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	side := rand.New(rand.NewSource(9))
+	primary := rand.New(rand.NewSource(5))
+	side.Int63n(1000000)
+	primary.Int63n(1000000)
+	sideNext, primaryNext := fmt.Sprint(side.Int63n(1000000)), fmt.Sprint(primary.Int63n(1000000))
+	expect := []string{sideNext, primaryNext, sideNext, primaryNext}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestRandMatchesMathRandSequence(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+INITRAND 42
+FOR LOCAL, 0, 1500
+	PRINTFORML {RAND:1000}
+NEXT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	ref := rand.New(rand.NewSource(42))
+	if len(out) != 1500 {
+		t.Fatalf("unexpected output count: %d", len(out))
+	}
+	for i := range out {
+		if want := fmt.Sprint(ref.Int63n(1000)); out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestPrintFormAlignShorthands(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+X = 7
+PRINTFORML [%"ab",5,C%]
+PRINTFORML [%"ab",5,l%]
+PRINTFORML [%"ab",5,r%]
+PRINTFORML [{X,4,L}]
+PRINTFORML [%"ab",5,BOGUS%]
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"[ ab  ]", "[ab   ]", "[   ab]", "[7   ]", "[   ab]"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestStringTruthiness(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+LOCALS:0 = ""
+LOCALS:1 = "0"
+LOCALS:2 = "abc"
+LOCALS:3 = "12"
+FOR LOCAL, 0, 4
+	IF LOCALS:LOCAL
+		PRINTL T
+	ELSE
+		PRINTL F
+	ENDIF
+NEXT
+PRINTFORML %LOCALS:1 ? "T" # "F"%%LOCALS:2 ? "T" # "F"%
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"F", "F", "T", "T", "FT"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestMarkTimeDeltaTime(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+DELTATIME
+PRINTVL RESULT
+MARKTIME
+DELTATIME
+PRINTVL RESULT
+DELTATIME
+PRINTVL RESULT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	now := time.Unix(1000, 0)
+	vm.SetClock(func() time.Time {
+		now = now.Add(250 * time.Millisecond)
+		return now
+	})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"0", "250", "500"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestCSVNamedConstantsAsValues(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+X = Stamina + 1
+PRINTVL X
+PRINTVL Nonexistent
+Strength = 9
+PRINTVL Strength
+PRINTVL Shared
+PRINTVL Agility
+Agility = 4
+PRINTVL Agility
+PRINTVL Vigor
+Vigor++
+PRINTVL Vigor
+STRDATA Motto
+    DATA onward
+ENDDATA
+PRINTVL Motto
+QUIT
+`,
+		"ABL.CSV":    "1,Strength\n2,Stamina\n3,Agility\n5,Shared\n7,Vigor\n8,Motto\n",
+		"TALENT.CSV": "6,Shared\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"3", "0", "9", "0", "0", "4", "0", "1", "onward"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestFormatValueMatchesPrintV(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTVL 42
+PRINTVL -7
+PRINTVL 0
+PRINTVL "text"
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	values := []eruntime.Value{eruntime.Int(42), eruntime.Int(-7), eruntime.Int(0), eruntime.Str("text")}
+	if len(out) != len(values) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, v := range values {
+		if got := eruntime.FormatValue(v); got != out[i].Text {
+			t.Fatalf("FormatValue mismatch at %d: got=%q printv=%q", i, got, out[i].Text)
+		}
+	}
+}
+
+func TestNoDataVariablesSkipSaveGame(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERH": `
+#DIM NODATA SCRATCH, 3
+#DIM SAVEDATA KEEP, 3
+`,
+		"MAIN.ERB": `
+@TITLE
+SCRATCH:1 = 5
+KEEP:1 = 6
+SAVEGAME 3
+SCRATCH:1 = 7
+KEEP:1 = 8
+LOADGAME 3
+PRINTFORML {SCRATCH:1},{KEEP:1}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "7,6" {
+		t.Fatalf("unexpected NODATA save behavior: %+v", out)
+	}
+}
+
+func TestStrictFormExpansionRejectsSelfReference(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERH": `
+#DEFINE LOOPY "a%LOOPY%"
+`,
+		"MAIN.ERB": `
+@TITLE
+PRINTFORML %LOOPY%
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if _, err := vm.Run("TITLE"); err != nil {
+		t.Fatalf("lenient run failed: %v", err)
+	}
+	vm.SetStrictFormExpansion(true)
+	_, err = vm.Run("TITLE")
+	if err == nil || !strings.Contains(err.Error(), "did not stabilize") {
+		t.Fatalf("expected stabilization error, got %v", err)
+	}
+}
+
+func TestGetCharaIndexAfterSwap(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 10
+ADDCHARA 20
+ADDCHARA 30
+SWAPCHARA 0, 2
+PRINTFORML {GETCHARAINDEX(10)},{GETCHARAINDEX(30)},{GETCHARAINDEX(99)}
+PRINTFORML {NO:0},{NO:2}
+DELCHARA 0
+GETCHARAINDEX 10
+PRINTVL RESULT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"2,0,-1", "30,10", "1"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestSetWindowTitleInvokesHook(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTFORML %GETWINDOWTITLE()%
+SETWINDOWTITLE "Town - Day " + TOSTR(3)
+PRINTFORML %GETWINDOWTITLE()%
+QUIT
+`,
+		"GAMEBASE.CSV": "WINDOWTITLE,Start\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	var titles []string
+	vm.SetTitleHook(func(title string) {
+		titles = append(titles, title)
+	})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 2 || out[0].Text != "Start" || out[1].Text != "Town - Day 3" {
+		t.Fatalf("unexpected title output: %+v", out)
+	}
+	if len(titles) != 1 || titles[0] != "Town - Day 3" || vm.WindowTitle() != "Town - Day 3" {
+		t.Fatalf("unexpected hook calls: %v", titles)
+	}
+}
+
+func TestSaveVarIndexRange(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERH": `
+#DIM ARR, 10
+`,
+		"MAIN.ERB": `
+@TITLE
+FOR LOCAL, 0, 10
+	ARR:LOCAL = LOCAL * 10
+NEXT
+SAVEVAR "window", "mes", ARR:3-5
+VARSET ARR, 1
+LOADVAR "window"
+PRINTFORML {ARR:2},{ARR:3},{ARR:4},{ARR:5},{ARR:6}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "0,30,40,50,0" {
+		t.Fatalf("unexpected ranged SAVEVAR output: %+v", out)
+	}
+}
+
+func TestSaveVarCharacterIndexRange(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERH": `
+#DIM DYNAMIC GROW
+`,
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 1
+ADDCHARA 1
+FOR LOCAL, 0, 8
+	CFLAG:1:LOCAL = LOCAL + 1
+	GROW:LOCAL = LOCAL + 1
+NEXT
+SAVEVAR "party", "mes", CFLAG:1:2-4, GROW:5-999999999999
+FOR LOCAL, 0, 8
+	CFLAG:1:LOCAL = 0
+	GROW:LOCAL = 0
+NEXT
+LOADVAR "party"
+PRINTFORML {CFLAG:1:1},{CFLAG:1:2},{CFLAG:1:3},{CFLAG:1:4},{CFLAG:1:5}
+PRINTFORML {GROW:4},{GROW:5},{GROW:7}
+SAVEVAR "party", "mes", CFLAG:1:0-999999999999
+LOADVAR "party"
+PRINTFORML {CFLAG:1:3}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"0,3,4,5,0", "0,6,8", "4"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected outputs: %+v", out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestPrintImgEmitsImageOutput(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTL before
+PRINTIMG "chara/" + TOSTR(12) + ".png"
+PRINTL after
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	if out[1].Image != "chara/12.png" || out[1].Text != "" {
+		t.Fatalf("unexpected image output: %+v", out[1])
+	}
+	if out[0].Image != "" || out[2].Text != "after" {
+		t.Fatalf("unexpected text outputs: %+v", out)
+	}
+}
+
+func TestSetNamedColorRegistry(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+SETCOLORBYNAME "danger"
+GETCOLOR
+PRINTVL RESULT
+PRINTFORML {COLOR_FROMNAME("DANGER")},{COLOR_FROMNAME("red")},{COLOR_FROMNAME("nope")}
+SETBGCOLORBYNAME "white"
+GETBGCOLOR
+PRINTVL RESULT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetNamedColor("DANGER", 0xC00010)
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"C00010", "12582928,16711680,0", "FFFFFF"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestSaveGameIsByteStable(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 1
+B = 2
+Z = 26
+FLAG:5 = 9
+CFLAG:0:3 = 4
+RESULT = 1
+SAVEGAME 1
+SAVEGAME 2
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	dir := t.TempDir()
+	vm.SetSaveDir(dir)
+	if _, err := vm.Run("TITLE"); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	first, err := os.ReadFile(filepath.Join(dir, "1.json"))
+	if err != nil {
+		t.Fatalf("read first save: %v", err)
+	}
+	second, err := os.ReadFile(filepath.Join(dir, "2.json"))
+	if err != nil {
+		t.Fatalf("read second save: %v", err)
+	}
+	if string(first) != string(second) {
+		t.Fatalf("saves of identical state differ:\n%s\n---\n%s", first, second)
+	}
+}
+
+func TestVarAndCharaSavesAreByteStable(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 1
+FLAG:5 = 9
+ADDCHARA 0
+CFLAG:0:3 = 4
+RESULT = 1
+SAVEVAR "v1", "m"
+SAVEVAR "v2", "m"
+SAVECHARA "c1", "m", 0
+SAVECHARA "c2", "m", 0
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	dir := t.TempDir()
+	vm.SetSaveDir(dir)
+	vm.SetClock(func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) })
+	if _, err := vm.Run("TITLE"); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	for _, pair := range [][2]string{{"var_v1.dat", "var_v2.dat"}, {"chara_c1.dat", "chara_c2.dat"}} {
+		first, err := os.ReadFile(filepath.Join(dir, pair[0]))
+		if err != nil {
+			t.Fatalf("read %s: %v", pair[0], err)
+		}
+		second, err := os.ReadFile(filepath.Join(dir, pair[1]))
+		if err != nil {
+			t.Fatalf("read %s: %v", pair[1], err)
+		}
+		if string(first) == string(second) {
+			continue
+		}
+		t.Fatalf("%s and %s differ:\n%s\n---\n%s", pair[0], pair[1], first, second)
+	}
+}
+
+func TestFormWidthCountsDisplayColumns(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+N = 1
+FORMWIDTH あい{N,4}b
+PRINTVL RESULT
+FORMWIDTH [%"ｱ",3,LEFT%]
+PRINTVL RESULT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 2 || out[0].Text != "9" || out[1].Text != "5" {
+		t.Fatalf("unexpected FORMWIDTH results: %+v", out)
+	}
+}
+
+func TestChainedComparisonIsRejected(t *testing.T) {
+	if _, err := parser.ParseExpr("1 < 2 < 3"); err == nil || !strings.Contains(err.Error(), "chained comparison") {
+		t.Fatalf("expected chained comparison error, got %v", err)
+	}
+	if _, err := parser.ParseExpr("(1 < 2) < 3"); err != nil {
+		t.Fatalf("parenthesized comparison rejected: %v", err)
+	}
+	if _, err := parser.ParseExpr("1 < 2 && 2 < 3"); err != nil {
+		t.Fatalf("joined comparison rejected: %v", err)
+	}
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+IF 1 < 2 < 3
+	PRINTL yes
+ENDIF
+QUIT
+`,
+	}
+	if _, err := erago.Compile(files); err == nil || !strings.Contains(err.Error(), "chained comparison") {
+		t.Fatalf("expected compile error for chained comparison, got %v", err)
+	}
+}
+
+func TestAtTernaryVerbatimBranchesExpand(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+S = "cat"
+N = 3
+X = 1
+PRINTFORML [@X ? @"a%S%b" # @"c{N}d"@]
+X = 0
+PRINTFORML [@X ? @"a%S%b" # @"c{N,3}d"@]
+PRINTFORML [@X == 0 ? "q%S%" # "r"@]
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"[acatb]", "[c  3d]", "[qcat]"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, want := range expect {
+		if out[i].Text != want {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, want)
+		}
+	}
+}
+
+func TestRandStreamsAreIndependent(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+RANDSTREAM "COSMETIC"
+INITRAND 5
+RANDSTREAM GAMEPLAY
+INITRAND 5
+RANDSTREAM "COSMETIC"
+A = RAND:1000000
+B = RAND:1000000
+C = RAND:1000000
+RANDSTREAM GAMEPLAY
+X = RAND:1000000
+RANDSTREAM
+D = RAND:1000000
+RANDSTREAM GAMEPLAY
+Y = RAND:1000000
+PRINTFORML {A == X},{B == Y},{A != B}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "1,1,1" {
+		t.Fatalf("unexpected stream comparison: %+v", out)
+	}
+}
+
+func TestSaveVarKeepsEmptyStringGlobals(t *testing.T) {
+	for _, format := range []string{"json", "binary"} {
+		files := map[string]string{
+			"MAIN.ERH": "#DIMS NAMEBUF\n",
+			"MAIN.ERB": `
+@TITLE
+NAMEBUF = ""
+SAVEVAR "empty", "m", NAMEBUF
+NAMEBUF = "stale"
+LOADVAR "empty"
+PRINTFORML [%NAMEBUF%]
+QUIT
+`,
+		}
+		vm, err := erago.Compile(files)
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		if err := vm.SetDatSaveFormat(format); err != nil {
+			t.Fatalf("set format failed: %v", err)
+		}
+		tmp := t.TempDir()
+		vm.SetSaveDir(tmp)
+		out, err := vm.Run("TITLE")
+		if err != nil {
+			t.Fatalf("%s: run failed: %v", format, err)
+		}
+		if len(out) != 1 || out[0].Text != "[]" {
+			t.Fatalf("%s: unexpected empty string round-trip: %+v", format, out)
+		}
+		if format != "json" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(tmp, "var_empty.dat"))
+		if err != nil {
+			t.Fatalf("read dat failed: %v", err)
+		}
+		if !strings.Contains(string(b), `"s": ""`) && !strings.Contains(string(b), `"s":""`) {
+			t.Fatalf("expected explicit empty string in snapshot: %s", b)
+		}
+	}
+}
+
+func TestChkDataCompatRejectsForeignSaves(t *testing.T) {
+	tmp := t.TempDir()
+	writer, err := erago.Compile(map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 3
+SAVEGAME 1
+QUIT
+`,
+	})
+	if err != nil {
+		t.Fatalf("compile writer failed: %v", err)
+	}
+	writer.SetSaveDir(tmp)
+	if _, err := writer.Run("TITLE"); err != nil {
+		t.Fatalf("writer run failed: %v", err)
+	}
+
+	check := `
+CHKDATACOMPAT 1
+PRINTVL RESULT
+CHKDATACOMPAT 2
+PRINTVL RESULT
+QUIT
+`
+	same, err := erago.Compile(map[string]string{
+		"MAIN.ERB": "\n@TITLE\nA = 3\nSAVEGAME 1\n" + check,
+	})
+	if err != nil {
+		t.Fatalf("compile same failed: %v", err)
+	}
+	same.SetSaveDir(tmp)
+	out, err := same.Run("TITLE")
+	if err != nil {
+		t.Fatalf("same run failed: %v", err)
+	}
+	if len(out) != 2 || out[0].Text != "1" || out[1].Text != "-1" {
+		t.Fatalf("unexpected same-identity compat outputs: %+v", out)
+	}
+
+	foreign, err := erago.Compile(map[string]string{
+		"MAIN.ERB": "\n@TITLE\nB = 4\n" + check,
+	})
+	if err != nil {
+		t.Fatalf("compile foreign failed: %v", err)
+	}
+	foreign.SetSaveDir(tmp)
+	out, err = foreign.Run("TITLE")
+	if err != nil {
+		t.Fatalf("foreign run failed: %v", err)
+	}
+	if len(out) != 2 || out[0].Text != "0" || out[1].Text != "-1" {
+		t.Fatalf("unexpected foreign compat outputs: %+v", out)
+	}
+}
+
+func TestToHalfToFullKatakana(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+TOFULL "ｶﾞﾝﾊﾞﾚ｡ﾊﾟﾝ ｳﾞｨ"
+PRINTVL RESULTS
+TOHALF "ガンバレ。パン　ヴィ"
+PRINTVL RESULTS
+TOHALF TOFULL("ｱｲｳｴｵｯｰ･ﾎﾟ")
+PRINTVL RESULTS
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"ガンバレ。パン　ヴィ", "ｶﾞﾝﾊﾞﾚ｡ﾊﾟﾝ ｳﾞｨ", "ｱｲｳｴｵｯｰ･ﾎﾟ"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestFormCallsStringReturningFunction(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTFORML [{GREET()}]
+PRINTFORML %RESULTS%
+PRINTFORML [{TWICE(4)}]
+QUIT
+
+@GREET
+#FUNCTIONS
+RETURNFORM hello
+@TWICE(X)
+#FUNCTION
+RETURNF X * 2
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"[hello]", "hello", "[8]"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestParseProgramLenientRecoversAtFunctions(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@BROKEN
+IF 1
+PRINTL never closed
+@GOOD
+PRINTL ok
+`,
+	}
+	if _, err := parser.ParseProgram(files); err == nil {
+		t.Fatalf("expected strict parse to fail")
+	}
+	prog, errs := parser.ParseProgramLenient(files)
+	if prog == nil {
+		t.Fatalf("expected partial program, errors: %v", errs)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected one error, got %v", errs)
+	}
+	if prog.Functions["BROKEN"] != nil {
+		t.Fatalf("broken function should be dropped")
+	}
+	if prog.Functions["GOOD"] == nil {
+		t.Fatalf("expected GOOD to parse after recovery")
+	}
+}
+
+func TestDimsInitializerLists(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERH": "#DIMS NAMES, 3 = \"a\", \"b\", \"c\"\n",
+		"MAIN.ERB": `
+@TITLE
+#DIMS LNAMES, 2 = "x", "y"
+#DIM LNUMS = 7, 8
+PRINTFORML %NAMES:1%|%LNAMES:1%|{LNUMS:1}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "b|y|8" {
+		t.Fatalf("unexpected initializer outputs: %+v", out)
+	}
+}
+
+func TestArgcCountsPassedArguments(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+CALL PAIR(1)
+CALL PAIR(1, 2)
+CALL PAIR
+PRINTFORML {PAIRCOUNT(5)}
+QUIT
+
+@PAIR(X, Y = 9)
+ARGC
+PRINTVL RESULT
+
+@PAIRCOUNT(X, Y)
+#FUNCTION
+RETURNF ARGC()
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"1", "2", "0", "1"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestCollapseSpacesInOutput(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+LOCALS = ""
+PRINTFORML %LOCALS,3%[%LOCALS%]  name:  %LOCALS%   end
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetCollapseSpaces(true)
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "   [] name: end" {
+		t.Fatalf("unexpected collapsed output: %+v", out)
+	}
+}
+
+func TestCharaExistsAndCharaNum(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ADDVOIDCHARA
+ADDVOIDCHARA
+CHARANUM
+PRINTVL RESULT
+CHARAEXISTS 1
+PRINTVL RESULT
+CHARAEXISTS 2
+PRINTVL RESULT
+PRINTFORML {CHARAEXISTS(-1)}/{CHARANUM()}/{CHARANUM}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"2", "1", "0", "0/2/2"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestPrintFormWMultilineWaitsOnce(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTFORMW first\nsecond\nthird
+INPUT
+PRINTVL RESULT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	waits := 0
+	var emitted, seenAtWait []string
+	vm.SetOutputHook(func(o eruntime.Output) {
+		emitted = append(emitted, o.Text)
+	})
+	vm.SetInputProvider(func(req eruntime.InputRequest) (string, bool, error) {
+		if req.Command == "WAITANYKEY" {
+			waits++
+			seenAtWait = append([]string(nil), emitted...)
+			return "", false, nil
+		}
+		return "42", false, nil
+	})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if waits != 1 {
+		t.Fatalf("expected one wait for the whole block, got %d", waits)
+	}
+	if joined := strings.Join(seenAtWait, "\n"); !strings.Contains(joined, "third") {
+		t.Fatalf("wait happened before the block finished emitting: %q", joined)
+	}
+	if last := out[len(out)-1].Text; last != "42" {
+		t.Fatalf("INPUT should read the provided value after the wait: got=%q out=%+v", last, out)
+	}
+}
+
+func TestDrawLinePatternFillsWidth(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+CUSTOMDRAWLINE "=-"
+LOCALS = ＊
+DRAWLINEFORM %LOCALS%-
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{
+		// Width is PRINTCPERLINE cells of PRINTCLENGTH columns: 3*27.
+		strings.Repeat("=-", 40) + "=",
+		strings.Repeat("＊-", 27),
+	}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestResultAccessorsAfterRun(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+RESULT = 17
+RESULTS = "done"
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if _, err := vm.Run("TITLE"); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := vm.Result().Int64(); got != 17 {
+		t.Fatalf("unexpected Result: %d", got)
+	}
+	if got := vm.ResultString(); got != "done" {
+		t.Fatalf("unexpected ResultString: %q", got)
+	}
+}
+
+func TestTrainCSVNames(t *testing.T) {
+	files := map[string]string{
+		"TRAIN.CSV": "0,愛撫\n3,キス\n",
+		"MAIN.ERB": `
+@TITLE
+CSVTRAIN 3
+PRINTVL RESULT
+PRINTFORML %TRAINNAME:0%|%CSVTRAIN(9)%|
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"キス", "愛撫||"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestMaxOutputsPolicies(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+FOR LOCAL, 0, 1000
+	PRINTFORML line {LOCAL}
+NEXT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetMaxOutputs(10, eruntime.OutputDropOldest)
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 10 || out[0].Text != "line 990" || out[9].Text != "line 999" {
+		t.Fatalf("unexpected bounded outputs: %d first=%+v", len(out), out[0])
+	}
+
+	vm, err = erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetMaxOutputs(10, eruntime.OutputLimitError)
+	if _, err := vm.Run("TITLE"); err == nil || !strings.Contains(err.Error(), "output limit exceeded") {
+		t.Fatalf("expected output limit error, got %v", err)
+	}
+
+	// The two limits are kept apart: clearing one leaves the other in force.
+	vm.SetMaxLogLines(5)
+	vm.SetMaxOutputs(0, eruntime.OutputDropOldest)
+	out, err = vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run with log limit failed: %v", err)
+	}
+	if len(out) != 5 || out[0].Text != "line 995" {
+		t.Fatalf("SetMaxOutputs cleared the log limit: %d first=%+v", len(out), out[0])
+	}
+	vm.SetMaxOutputs(3, eruntime.OutputDropOldest)
+	if out, err = vm.Run("TITLE"); err != nil || len(out) != 3 || out[0].Text != "line 997" {
+		t.Fatalf("smaller drop limit should win: %v %+v", err, out)
+	}
+	vm.SetMaxOutputs(0, eruntime.OutputDropOldest)
+	vm.SetMaxLogLines(0)
+	if out, err = vm.Run("TITLE"); err != nil || len(out) != 1000 {
+		t.Fatalf("expected unbounded output: %v %d", err, len(out))
+	}
+}
+
+func TestSelectCaseTrailingCommaFallsThrough(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+FOR LOCAL, 1, 6
+	CALL PICK(LOCAL)
+NEXT
+QUIT
+
+@PICK(X)
+SELECTCASE X
+	CASE 1,
+	CASE 2,
+		PRINTFORM two-
+	CASE 3
+		PRINTFORML three
+	CASE 4,
+		PRINTFORM four-
+	CASEELSE
+		PRINTFORML else
+ENDSELECT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var lines []string
+	line := ""
+	for _, o := range out {
+		line += o.Text
+		if o.NewLine {
+			lines = append(lines, line)
+			line = ""
+		}
+	}
+	expect := []string{"two-three", "two-three", "three", "four-else", "else"}
+	if len(lines) != len(expect) {
+		t.Fatalf("unexpected line count: %d (%q)", len(lines), lines)
+	}
+	for i, exp := range expect {
+		if lines[i] != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, lines[i], exp)
+		}
+	}
+}
+
+func TestSyncNoFollowsRoster(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ADDVOIDCHARA
+ADDVOIDCHARA
+ADDVOIDCHARA
+NO:0 = 10
+NO:1 = 20
+NO:2 = 30
+SWAPCHARA 0, 2
+SYNCNO
+PRINTVL RESULT
+GETCHARA 0
+PRINTFORML {NO:0}/{RESULT}
+FINDELEMENT NO, 10
+PRINTVL RESULT
+NO:0 = 20
+PRINTFORML {NO:0},{NO:1},{NO:2}
+FINDELEMENT NO, 30
+PRINTVL RESULT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"3", "30/30", "2", "20,30,10", "1"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestEventHandlersRunInPriorityOrder(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": "\n@TITLE\nBEGIN FIRST\n",
+		"A.ERB":    "\n@EVENTFIRST\nPRINTL a\nRETURN 0\n",
+		"B.ERB":    "\n@EVENTFIRST\nPRINTL b\n",
+		"C.ERB":    "\n@EVENTFIRST\n#PRI\nPRINTL c\n",
+		"D.ERB":    "\n@EVENTFIRST\nPRINTL d\nQUIT\n",
+		"E.ERB":    "\n@EVENTFIRST\nPRINTL e\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"c", "a", "b", "d"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+Y = 10
+X = Y / 3.0 * 2
+PRINTVL X
+PRINTVL Y / 3
+PRINTVL 1.50 + 1
+PRINTVL -2.5 * 2
+PRINTVL SQRT(2.25)
+PRINTVL SQRT(5)
+PRINTVL POWER(4, 0.5)
+PRINTVL POWER(2, 10)
+X = 0.5
+X += 0.25
+PRINTVL X
+PRINTVL 0.1 < 0.2
+SIF 0.0
+	PRINTL zero is truthy
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"6.666666666666667", "3", "2.5", "-5", "1.5", "2", "2", "1024", "0.75", "1"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+	if v := vm.Globals()["X"]; v.Kind() != eruntime.FloatKind || v.Float64() != 0.75 {
+		t.Fatalf("expected float global, got %+v", v)
+	}
+}
+
+func TestFloatArrayElementsAndSelectCase(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A:0 = 1.5
+A:1 = A:0 * 2
+PRINTFORML {A:0},{A:1}
+SELECTCASE 1.5
+	CASE 1
+		PRINTL one
+	CASE 1.5
+		PRINTL one and a half
+ENDSELECT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"1.5,3", "one and a half"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestInputRejectsNonNumericAnswers(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+INPUT
+PRINTFORML {RESULT}/{RESULT:1}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	answers := []string{"abc", "5"}
+	var rejected []string
+	vm.SetInputProvider(func(req eruntime.InputRequest) (string, bool, error) {
+		rejected = append(rejected, req.Rejected)
+		v := answers[0]
+		answers = answers[1:]
+		return v, false, nil
+	})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) == 0 || out[len(out)-1].Text != "5/0" {
+		t.Fatalf("unexpected provider INPUT outputs: %+v", out)
+	}
+	if len(rejected) != 2 || rejected[0] != "" || rejected[1] != "abc" {
+		t.Fatalf("expected a re-prompt carrying the rejected answer, got %q", rejected)
+	}
+
+	vm, err = erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.EnqueueInput("abc")
+	out, err = vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) == 0 || out[len(out)-1].Text != "0/1" {
+		t.Fatalf("unexpected headless INPUT outputs: %+v", out)
+	}
+}
+
+func TestInputRetriesAreBounded(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+RESULT:1 = 7
+INPUT
+PRINTFORML {RESULT}/{RESULT:1}
+RESULT:1 = 7
+INPUT
+PRINTFORML {RESULT}/{RESULT:1}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	calls := 0
+	vm.SetInputProvider(func(req eruntime.InputRequest) (string, bool, error) {
+		calls++
+		if calls == 1 {
+			return "5", false, nil
+		}
+		return "abc", false, nil
+	})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"5", "5/0", "0", "0/1"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+	if calls < 3 || calls > 100 {
+		t.Fatalf("expected a bounded number of re-prompts, got %d calls", calls)
+	}
+
+	// Headless, a rejected answer flags RESULT:1 and the next accepted one
+	// clears it again.
+	vm, err = erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.EnqueueInput("abc", "5")
+	out, err = vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect = []string{"0", "0/1", "5", "5/0"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+
+	vm, err = erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	vm.SetInputProvider(func(req eruntime.InputRequest) (string, bool, error) {
+		cancel()
+		return "abc", false, nil
+	})
+	if _, err := vm.RunContext(ctx, "TITLE"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation to stop re-prompting, got %v", err)
+	}
+}
+
+func TestTernaryKeepsStringBranches(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+#DIMS LABEL
+A = 1
+LABEL = A ? "yes" # "no"
+PRINTFORML %LABEL%
+LABEL = A == 0 ? "yes" # A == 1 ? "one" # "many"
+PRINTFORML %LABEL%
+PRINTFORML %A > 5 ? "big" # "small"%
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"yes", "one", "small"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i, exp := range expect {
+		if out[i].Text != exp {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, exp)
+		}
+	}
+}
+
+func TestRandLogRecordsSeedsAndResults(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+INITRAND 42
+A = RAND:100
+B = RAND(1000)
+PRINTFORML {A},{B}
+PRINTDATAL
+    DATA zero
+    DATA one
+    DATA two
+ENDDATA
+DUMPRAND
+PRINTFORML {RESULT}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetRandLogging(true)
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	log := vm.RandLog()
+	if len(log) != 5 || log[0] != (eruntime.RandEvent{Kind: eruntime.RandSeed, Value: 42}) {
+		t.Fatalf("unexpected rand log: %+v", log)
+	}
+	for i, ev := range log[1:] {
+		if ev.Kind != eruntime.RandDraw {
+			t.Fatalf("entry %d should be a draw: %+v", i+1, log)
+		}
+	}
+	if len(out) != 3 {
+		t.Fatalf("unexpected output: %+v", out)
+	}
+	if got, want := out[0].Text, fmt.Sprintf("%d,%d", log[1].Value, log[2].Value); got != want {
+		t.Fatalf("rand log does not match output: got=%q want=%q", got, want)
+	}
+	if got, want := out[1].Text, []string{"zero", "one", "two"}[log[3].Value]; got != want {
+		t.Fatalf("PRINTDATA pick does not match log: got=%q want=%q", got, want)
+	}
+	ref := rand.New(rand.NewSource(42))
+	ref.Int63n(100)
+	ref.Int63n(1000)
+	if got, want := log[3].Value, int64(ref.Intn(3)); got != want {
+		t.Fatalf("PRINTDATA pick = %d, want the Intn draw %d", got, want)
+	}
+	if got, want := out[2].Text, fmt.Sprint(log[4].Value); got != want {
+		t.Fatalf("DUMPRAND does not match log: got=%q want=%q", got, want)
+	}
+	out, err = vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("rerun failed: %v", err)
+	}
+	if again := vm.RandLog(); !slices.Equal(again, log) {
+		t.Fatalf("replay diverged: %+v vs %+v (%+v)", again, log, out)
+	}
+}
+
+func TestAddCharaCopiesCSVDefaults(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 42
+PRINTFORML {BASE:0:1},{MAXBASE:0:1},{ABL:0:2},{TALENT:0:3},{MARK:0:0}
+PRINTFORML {MARK:0:1},{CFLAG:0:2},{JUEL:0:0}
+ADDVOIDCHARA
+ADDCHARA 0
+PRINTFORML {NO:1},{BASE:1:1},{NO:2},{BASE:2:1}
+QUIT
+`,
+		"ABL.CSV":     "2,Technique\n",
+		"CHARA42.CSV": "番号,42\n名前,Remilia\n基礎,1,1500\n能力,Technique,4\n素質,3\nMARK,0,2\n刻印,1,3\nフラグ,2,5\n珠,0,7\n",
+		"CHARA0.CSV":  "番号,0\n基礎,1,100\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"1500,1500,4,1,2", "3,5,7", "0,0,0,100"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+	chars := vm.Characters()
+	if len(chars) != 3 || chars[0].Vars["ABL:2"].Int64() != 4 || chars[0].Vars["BASE:1"].Int64() != 1500 {
+		t.Fatalf("CSV defaults not copied into Vars: %+v", chars)
+	}
+}
+
+func TestRunContextCancelsRunawayLoop(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+WHILE 1
+    A += 1
+WEND
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetStepLimit(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := vm.RunContext(ctx, "TITLE"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+
+	vm.SetStepLimit(1000)
+	_, err = vm.Run("TITLE")
+	if err == nil || !strings.Contains(err.Error(), "step limit exceeded") {
+		t.Fatalf("expected step limit error, got %v", err)
+	}
+}
+
+func TestFormPlaceholdersIndexByTarget(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 1
+ADDCHARA 2
+TARGET = 1
+MASTER = 0
+ABL:TARGET:힘 = 7
+ABL:MASTER:힘 = 3
+CSTR:TARGET:0 = "tgt"
+PRINTFORML {ABL:TARGET:힘}/{ABL:MASTER:힘}/{ABL:1:2}/{TARGET}
+PRINTFORML %CSTR:TARGET:0%/{ABL:(TARGET - 1):힘}
+QUIT
+`,
+		"ABL.CSV": "2,힘\n",
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"7/3/7/1", "tgt/3"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestHostPresetsGlobals(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTFORML {FLAG:10},{A},%NAMEDSTR%
+FLAG:11 = FLAG:10 * 2
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if err := vm.SetGlobalArray("FLAG", []int64{10}, eruntime.Int(21)); err != nil {
+		t.Fatalf("SetGlobalArray failed: %v", err)
+	}
+	if err := vm.SetGlobalInt("A", 5); err != nil {
+		t.Fatalf("SetGlobalInt failed: %v", err)
+	}
+	if err := vm.SetGlobalString("NAMEDSTR", "host"); err != nil {
+		t.Fatalf("SetGlobalString failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "21,5,host" {
+		t.Fatalf("unexpected output: %+v", out)
+	}
+	v, err := vm.GetGlobalArray("FLAG", []int64{11})
+	if err != nil {
+		t.Fatalf("GetGlobalArray failed: %v", err)
+	}
+	if v.Int64() != 42 {
+		t.Fatalf("FLAG:11 = %d, want 42", v.Int64())
+	}
+}
+
+func TestCompileStatsCountsStatements(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 1
+IF A
+    PRINTL yes
+ELSE
+    PRINTL no
+ENDIF
+FOR I, 0, 3
+    A += I
+NEXT
+CALL HELPER
+QUIT
+
+@HELPER
+SELECTCASE A
+    CASE 1
+        PRINTL one
+ENDSELECT
+RETURN 0
+
+@EVENTFIRST
+PRINTL a
+
+@EVENTFIRST
+PRINTL b
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	stats := vm.CompileStats()
+	if stats.Functions != 4 {
+		t.Fatalf("Functions = %d, want 4", stats.Functions)
+	}
+	if stats.Statements != 13 {
+		t.Fatalf("Statements = %d, want 13", stats.Statements)
+	}
+	if stats.ParseDuration <= 0 {
+		t.Fatalf("ParseDuration not recorded: %v", stats.ParseDuration)
+	}
+}
+
+func TestGetSetCharaData(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 1
+ADDCHARA 2
+SETCHARADATA 1, "MOOD", 3
+PRINTVL RESULT
+SETCHARADATA 1, "NOTE", "happy"
+GETCHARADATA 1, "MOOD"
+PRINTVL RESULT
+GETCHARADATA 1, "NOTE"
+PRINTSL RESULTS
+PRINTFORML {GETCHARADATA(0, "MOOD")},{SETCHARADATA(5, "MOOD", 1)}
+SETCHARADATA 1, "cflag:3", "7"
+PRINTFORML {CFLAG:1:3},{GETCHARADATA(1, "mood")},{GETCHARADATA(1, "Cflag:3") + 1}
+SETCHARADATA 1, "Note", 5
+GETCHARADATA 1, "note"
+PRINTSL RESULTS + "!"
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"1", "3", "happy", "0,0", "7,3,8", "5!"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+	if got := vm.Characters()[1].Vars["MOOD"].Int64(); got != 3 {
+		t.Fatalf("MOOD = %d, want 3", got)
+	}
+	if got := vm.Characters()[1].Vars["CFLAG:3"]; got.Kind() != eruntime.IntKind || got.Int64() != 7 {
+		t.Fatalf("CFLAG:3 = %#v, want integer 7", got)
+	}
+}
+
+func TestStreamWriterSkipsBuffer(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTL a
+PRINT b
+PRINTL c
+CLEARLINE 1
+REUSELASTLINE
+PRINTFORML {LINECOUNT}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	var buf strings.Builder
+	vm.SetStreamWriter(&buf)
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 0 {
+		t.Fatalf("streaming run should not buffer outputs: %+v", out)
+	}
+	if got := buf.String(); got != "a\nbc\n3\n" {
+		t.Fatalf("unexpected stream: %q", got)
+	}
+}
+
+func TestPrintCColumns(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTCPERLINE 2
+PRINTLC ab
+PRINTLC cd
+ALIGNMENT "RIGHT"
+PRINTC ef
+PRINTL tail
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	cell := func(s string, right bool) string {
+		pad := strings.Repeat(" ", 27-len(s))
+		if right {
+			return pad + s + " "
+		}
+		return s + pad
+	}
+	expect := []eruntime.Output{
+		{Text: cell("ab", false)},
+		{Text: cell("cd", false), NewLine: true},
+		{Text: cell("ef", true)},
+		{NewLine: true},
+		{Text: "tail", NewLine: true},
+	}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i].Text || out[i].NewLine != expect[i].NewLine {
+			t.Fatalf("unexpected output at %d: got=%+v want=%+v", i, out[i], expect[i])
+		}
+	}
+}
+
+func TestSignedStr(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = -3
+PRINTFORML %SIGNEDSTR(5)%,%SIGNEDSTR(A)%,%SIGNEDSTR(0)%,%SIGNEDSTR(1.5)%
+SIGNEDSTR 12
+PRINTSL RESULTS
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"+5,-3,0,+1.5", "+12"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestSaveInfoReadsSlotMessages(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 1
+SAVEDATA 1, "day 3"
+SAVEVAR "binvar", "bin note", A
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	if err := vm.SetDatSaveFormat("binary"); err != nil {
+		t.Fatalf("set format: %v", err)
+	}
+	before := time.Now().Add(-time.Minute)
+	if _, err := vm.Run("TITLE"); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	exists, mes, at, err := vm.SaveInfo("1")
+	if err != nil || !exists || mes != "day 3" || at.Before(before) {
+		t.Fatalf("slot info = %v %q %v %v", exists, mes, at, err)
+	}
+	exists, mes, _, err = vm.SaveInfo("binvar")
+	if err != nil || !exists || mes != "bin note" {
+		t.Fatalf("binary var info = %v %q %v", exists, mes, err)
+	}
+	if exists, _, _, err := vm.SaveInfo("missing"); err != nil || exists {
+		t.Fatalf("missing slot = %v %v", exists, err)
+	}
+
+	// Only the header is decoded: a broken body after SAVEDATA_TEXT or
+	// after the var save header does not matter.
+	dir := t.TempDir()
+	vm.SetSaveDir(dir)
+	if err := os.WriteFile(filepath.Join(dir, "2.json"), []byte(`{"unique_code":0,"globals":{"A":{"kind":"int","i":1},"SAVEDATA_TEXT":{"kind":"string","s":"day 4"},"B":`), 0o644); err != nil {
+		t.Fatalf("write slot: %v", err)
+	}
+	if exists, mes, _, err := vm.SaveInfo("2"); err != nil || !exists || mes != "day 4" {
+		t.Fatalf("partial slot info = %v %q %v", exists, mes, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "var_note.json"), []byte(`{"format":"erago.var.v1","saved_at":"2024-05-06T07:08:09Z","save_mes":"json note","globals":`), 0o644); err != nil {
+		t.Fatalf("write var save: %v", err)
+	}
+	exists, mes, at, err = vm.SaveInfo("note")
+	if err != nil || !exists || mes != "json note" || !at.Equal(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)) {
+		t.Fatalf("json var info = %v %q %v %v", exists, mes, at, err)
+	}
+	missingDir := filepath.Join(dir, "absent")
+	vm.SetSaveDir(missingDir)
+	if exists, _, _, err := vm.SaveInfo("1"); err != nil || exists {
+		t.Fatalf("slot in missing dir = %v %v", exists, err)
+	}
+	if _, err := os.Stat(missingDir); !os.IsNotExist(err) {
+		t.Fatalf("SaveInfo created the save directory: %v", err)
+	}
+}
+
+func TestLastInputSurvivesResultWrites(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+INPUT
+RESULT = 99
+RESULTS = "other"
+STRLENS "abc"
+LASTINPUT
+PRINTFORML %RESULTS%,{RESULT}
+INPUTS
+LASTINPUT
+PRINTFORML %RESULTS%/%LASTINPUT()%
+WAIT
+PRINTW waiting
+FORCEWAIT
+PRINTFORML %LASTINPUT()%/after
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.EnqueueInput("42", "hello")
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var got []string
+	for _, o := range out {
+		if strings.Contains(o.Text, ",") || strings.Contains(o.Text, "/") {
+			got = append(got, o.Text)
+		}
+	}
+	expect := []string{"42,3", "hello/hello", "hello/after"}
+	if len(got) != len(expect) {
+		t.Fatalf("unexpected output: %+v", out)
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, got[i], expect[i])
+		}
+	}
+}
+
+func TestMoneyStrNumberFormat(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTFORML %MONEYSTR(1234567)%,%MONEYSTR(-1000)%,%MONEYSTR(999)%,%MONEYSTR(12345.5)%
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "1234567,-1000,999,12345.5" {
+		t.Fatalf("unexpected default format: %+v", out)
+	}
+	vm.SetNumberFormat(".", ",")
+	out, err = vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "1.234.567,-1.000,999,12.345,5" {
+		t.Fatalf("unexpected custom format: %+v", out)
+	}
+}
+
+func TestListSavesNamesSlots(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERH": "#DIMS SLOTNAME\n",
+		"MAIN.ERB": `
+@TITLE
+A = 1
+SAVEDATA 2
+SAVEDATA 10
+SAVEGLOBAL
+SAVEVAR "party", "crew", A
+ADDCHARA 1
+SAVECHARA "roster", "", 0
+QUIT
+
+@RELOAD
+A = 0
+LOADVAR SLOTNAME
+PRINTFORML {A}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if got, err := vm.ListSaves(); err != nil || len(got) != 0 {
+		t.Fatalf("unset save dir = %v %v", got, err)
+	}
+	dir := t.TempDir()
+	vm.SetSaveDir(dir)
+	if err := vm.SetDatSaveFormat("both"); err != nil {
+		t.Fatalf("set format: %v", err)
+	}
+	if _, err := vm.Run("TITLE"); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	for name, content := range map[string]string{
+		"package.json":  `{"name": "app"}`,
+		"var_junk.json": `{"format": "other"}`,
+		"notes.dat":     "plain text",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	got, err := vm.ListSaves()
+	if err != nil {
+		t.Fatalf("ListSaves failed: %v", err)
+	}
+	expect := []string{"2", "10", "global", "party"}
+	if strings.Join(got, ",") != strings.Join(expect, ",") {
+		t.Fatalf("ListSaves = %v, want %v", got, expect)
+	}
+	for _, name := range got {
+		exists, _, _, err := vm.SaveInfo(name)
+		if err != nil || !exists {
+			t.Fatalf("SaveInfo(%q) = %v %v", name, exists, err)
+		}
+	}
+	exists, mes, _, err := vm.SaveInfo(got[3])
+	if err != nil || !exists || mes != "crew" {
+		t.Fatalf("SaveInfo(%q) = %v %q %v", got[3], exists, mes, err)
+	}
+	if err := vm.SetGlobalString("SLOTNAME", got[3]); err != nil {
+		t.Fatalf("set slot name: %v", err)
+	}
+	out, err := vm.Run("RELOAD")
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "1" {
+		t.Fatalf("LOADVAR %q output: %+v", got[3], out)
+	}
+}
+
+func TestCompactArrayDropsDefaults(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+FOR I, 0, 5
+    FLAG:I = I + 1
+NEXT
+FLAG:1 = 0
+FLAG:3 = 0
+COMPACTARRAY FLAG
+PRINTVL RESULT
+PRINTFORML {FLAG:0},{FLAG:1},{FLAG:4}
+COMPACTARRAY FLAG
+PRINTVL RESULT
+FLAG:4 = 0
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"2", "1,0,5", "0"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+	if n := vm.CompactAll(); n < 1 {
+		t.Fatalf("CompactAll removed %d, want at least FLAG:4", n)
+	}
+	if n := vm.CompactAll(); n != 0 {
+		t.Fatalf("second CompactAll removed %d, want 0", n)
+	}
+}
+
+func TestCompressedBinarySavesRoundTrip(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 9
+FLAG:500 = 22
+ADDCHARA 3
+SAVEVAR "zipped", "memo", A, FLAG
+SAVECHARA "party", "memo", 0
+A = 0
+FLAG:500 = 0
+DELALLCHARA
+LOADVAR "zipped"
+LOADCHARA "party"
+PRINTFORML {A},{FLAG:500},{CHARANUM}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if err := vm.SetDatSaveFormat("binary"); err != nil {
+		t.Fatalf("set format failed: %v", err)
+	}
+	vm.SetSaveCompression(true)
+	tmp := t.TempDir()
+	vm.SetSaveDir(tmp)
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "9,22,1" {
+		t.Fatalf("unexpected compressed round trip: %+v", out)
+	}
+	b, err := os.ReadFile(filepath.Join(tmp, "var_zipped.dat"))
+	if err != nil {
+		t.Fatalf("read dat failed: %v", err)
+	}
+	if len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+		t.Fatalf("expected gzip-compressed dat")
+	}
+	if !eruntime.IsEraBinaryData(b) {
+		t.Fatalf("IsEraBinaryData should see through gzip")
+	}
+}
+
+func TestVarKindClassifiesVariables(t *testing.T) {
+	files := map[string]string{
+		"DIM.ERH": "#DIM SCORES, 10\n#DIMS LABELS, 3\n#DIMS TITLE_TEXT\n#DIM COUNTER\n",
+		"MAIN.ERB": `
+@TITLE
+GREETING = "hi"
+LEVEL = 4
+PRINTFORML {VARKIND("SCORES")},{VARKIND("LABELS")},{VARKIND("GREETING")},{VARKIND("LEVEL")},{VARKIND("NOPE")}
+PRINTFORML {VARKIND("TITLE_TEXT")},{VARKIND("COUNTER")}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"3,4,2,1,0", "4,3"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestSaveCompatModeLenientAcceptsPatchedScripts(t *testing.T) {
+	dir := t.TempDir()
+	v1, err := erago.Compile(map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 42
+SAVEVAR "patch", "m", A
+QUIT
+`,
+	})
+	if err != nil {
+		t.Fatalf("compile v1 failed: %v", err)
+	}
+	v1.SetSaveDir(dir)
+	if err := v1.SetDatSaveFormat("binary"); err != nil {
+		t.Fatalf("set format failed: %v", err)
+	}
+	if _, err := v1.Run("TITLE"); err != nil {
+		t.Fatalf("v1 run failed: %v", err)
+	}
+
+	patched := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+LOADVAR "patch"
+PRINTVL A
+QUIT
+
+@NEW_FEATURE
+PRINTL added
+`,
+	}
+	strict, err := erago.Compile(patched)
+	if err != nil {
+		t.Fatalf("compile v2 failed: %v", err)
+	}
+	strict.SetSaveDir(dir)
+	if _, err := strict.Run("TITLE"); err == nil || !strings.Contains(err.Error(), "incompatible unique code") {
+		t.Fatalf("expected strict unique code mismatch, got %v", err)
+	}
+
+	lenient, err := erago.Compile(patched)
+	if err != nil {
+		t.Fatalf("compile v2 failed: %v", err)
+	}
+	lenient.SetSaveDir(dir)
+	if err := lenient.SetSaveCompatMode("lenient"); err != nil {
+		t.Fatalf("set compat mode failed: %v", err)
+	}
+	if err := lenient.SetSaveCompatMode("loose"); err == nil {
+		t.Fatalf("expected invalid compat mode error")
+	}
+	out, err := lenient.Run("TITLE")
+	if err != nil {
+		t.Fatalf("lenient run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "42" {
+		t.Fatalf("unexpected lenient LOADVAR output: %+v", out)
+	}
+}
+
+func TestFormReadsResultElements(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+CALL TRIPLE
+PRINTFORML %RESULT:2%/{RESULT:1}/{RESULT}
+X = 2
+PRINTFORML {RESULT:X}
+CALL NAMES
+PRINTFORML %RESULTS:1%
+QUIT
+
+@TRIPLE
+RETURN 10, 20, 30
+
+@NAMES
+RETURN "a", "b"
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"30/20/10", "30", "b"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestBinarySaveFormatRejectsDeepDims(t *testing.T) {
+	files := map[string]string{
+		"DIM.ERH": "#DIM GRID, 2, 2, 2\n#DIM DEEP, 2, 2, 2, 2\n#DIM NODATA SCRATCH, 2, 2, 2, 2\n",
+		"MAIN.ERB": `
+@TITLE
+DEEP:1:1:1:1 = 7
+PRINTFORML {DEEP:1:1:1:1}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "7" {
+		t.Fatalf("unexpected output: %+v", out)
+	}
+	if err := vm.SetDatSaveFormat("json"); err != nil {
+		t.Fatalf("json format rejected: %v", err)
+	}
+	err = vm.SetDatSaveFormat("binary")
+	if err == nil {
+		t.Fatalf("expected 4D declaration to be rejected for binary saves")
+	}
+	if !strings.Contains(err.Error(), "DIM.ERH:2") || !strings.Contains(err.Error(), "DEEP") {
+		t.Fatalf("expected error naming DIM.ERH:2 and DEEP, got %v", err)
+	}
+	if vm.DatSaveFormat() != "json" {
+		t.Fatalf("format changed despite error: %q", vm.DatSaveFormat())
+	}
+}
+
+func TestCSVFileListNamesLoadedFiles(t *testing.T) {
+	files := map[string]string{
+		"CSV/Item.csv":  "0,Sword\n",
+		"CSV/Abl.csv":   "0,Skill\n",
+		"MAIN.ERB": `
+@TITLE
+CSVFILELIST
+PRINTFORML {RESULT}:%RESULTS:0%,%RESULTS:1%
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"2:Abl.csv,Item.csv"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestStringKeyedArrayElements(t *testing.T) {
+	files := map[string]string{
+		"Flag.csv": "0,DAY\n",
+		"MAIN.ERB": `
+@TITLE
+FLAG:"quest_started" = 3
+FLAG:"quest_started" += 2
+FLAG:"DAY" = 9
+FLAG:2 = 7
+PRINTFORML {FLAG:"quest_started"},{FLAG:"other"},{FLAG:0},{FLAG:2}
+SAVEDATA 0, "named"
+RESETDATA
+PRINTFORML {FLAG:"quest_started"},{FLAG:2}
+LOADDATA 0
+PRINTFORML {FLAG:"quest_started"},{FLAG:0},{FLAG:2}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"5,0,9,7", "0,0", "5,9,7"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestBinarySaveVarRejectsNamedElements(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+FLAG:"quest_started" = 3
+FLAG:2 = 7
+SAVEVAR "named", "m", FLAG
+FLAG:"quest_started" = 0
+FLAG:2 = 0
+LOADVAR "named"
+PRINTFORML {FLAG:"quest_started"},{FLAG:2}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("json run failed: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "3,7" {
+		t.Fatalf("unexpected json round trip: %+v", out)
+	}
+
+	vm, err = erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.SetSaveDir(t.TempDir())
+	if err := vm.SetDatSaveFormat("binary"); err != nil {
+		t.Fatalf("set format: %v", err)
+	}
+	if _, err := vm.Run("TITLE"); err == nil || !strings.Contains(err.Error(), `named element "quest_started"`) {
+		t.Fatalf("expected binary SAVEVAR to reject the named element, got %v", err)
+	}
+}
+
+func TestRegexpReplace(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+REGEXPREPLACE "a1b22c333", "[0-9]+", "#"
+PRINTFORML {RESULT}:%RESULTS%
+REGEXPREPLACE "John Smith", "(\\w+) (\\w+)", "$2 $1"
+PRINTFORML {RESULT}:%RESULTS%
+N = REGEXPREPLACE("none", "x", "y")
+PRINTFORML {N}:%RESULTS%
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"3:a#b#c#", "1:Smith John", "0:none"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+
+	bad, err := erago.Compile(map[string]string{
+		"MAIN.ERB": `
+@TITLE
+REGEXPREPLACE "abc", "(", "x"
+QUIT
+`,
+	})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if _, err := bad.Run("TITLE"); err == nil || !strings.Contains(err.Error(), "invalid pattern") {
+		t.Fatalf("expected invalid pattern error, got %v", err)
+	}
+}
+
+func TestIncDecOnCSVNamedCharacterStat(t *testing.T) {
+	files := map[string]string{
+		"Abl.csv":    "0,힘\n1,기술\n",
+		"Chara1.csv": "번호,1\n이름,A\n",
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 1
+ABL:0:힘 = 2
+ABL:0:힘++
+++ABL:0:힘
+ABL:0:기술--
+PRINTFORML {ABL:0:힘},{ABL:0:0},{ABL:0:기술},{ABL:0:1}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"4,4,-1,-1"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestOutputCarriesStyle(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTL plain
+SETCOLOR "FF0000"
+SETBGCOLOR "112233"
+FONTBOLD
+ALIGNMENT "CENTER"
+PRINTL styled
+FONTREGULAR
+FONTITALIC
+RESETCOLOR
+RESETBGCOLOR
+ALIGNMENT "LEFT"
+PRINTL italic
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []eruntime.Output{
+		{Text: "plain", NewLine: true, Color: "FFFFFF", BgColor: "000000", Align: "LEFT"},
+		{Text: "styled", NewLine: true, Color: "FF0000", BgColor: "112233", Bold: true, Align: "CENTER"},
+		{Text: "italic", NewLine: true, Color: "FFFFFF", BgColor: "000000", Italic: true, Align: "LEFT"},
+	}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i] != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%+v want=%+v", i, out[i], expect[i])
+		}
+	}
+}
+
+func TestBatchDefersOutputHook(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTL before
+BEGINBATCH
+FOR I, 0, 5
+	PRINTFORML working {I}
+	CLEARLINE 1
+NEXT
+PRINTL done
+ENDBATCH
+PRINTL after
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	var hooked []string
+	vm.SetOutputHook(func(o eruntime.Output) {
+		hooked = append(hooked, o.Text)
+	})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"before", "done", "after"}
+	if len(hooked) != len(expect) {
+		t.Fatalf("unexpected hook calls: %q", hooked)
+	}
+	for i := range expect {
+		if hooked[i] != expect[i] {
+			t.Fatalf("unexpected hook call at %d: got=%q want=%q", i, hooked[i], expect[i])
+		}
+	}
+	if len(out) != 3 {
+		t.Fatalf("unexpected outputs: %+v", out)
+	}
+
+	hooked = nil
+	vm.WithBatch(func() {
+		if _, err := vm.Run("TITLE"); err != nil {
+			t.Fatalf("batched run failed: %v", err)
+		}
+		if len(hooked) != 0 {
+			t.Fatalf("hook called inside WithBatch: %q", hooked)
+		}
+	})
+	if len(hooked) != len(expect) {
+		t.Fatalf("unexpected hook calls after WithBatch: %q", hooked)
+	}
+}
+
+func TestPlusConcatenatesStringAndNumber(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTFORML %"a" + 1%
+PRINTFORML %1 + "b"%
+LOCALS = "v=" + 5
+PRINTFORML %LOCALS%
+PRINTFORML {2 + 3}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"a1", "1b", "v=5", "5"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestPrintButtonCarriesValue(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+N = 3
+PRINTBUTTON "[Attack]", N
+PRINTL
+PRINTBUTTON "[Name]", "alice"
+PRINTL
+PRINTL plain
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var buttons []eruntime.Output
+	for _, o := range out {
+		if o.IsButton {
+			buttons = append(buttons, o)
+		} else if o.ButtonValue != "" {
+			t.Fatalf("non-button output carries a value: %+v", o)
+		}
+	}
+	if len(buttons) != 2 {
+		t.Fatalf("unexpected button outputs: %+v", out)
+	}
+	if buttons[0].Text != "[Attack]" || buttons[0].ButtonValue != "3" {
+		t.Fatalf("unexpected first button: %+v", buttons[0])
+	}
+	if buttons[1].Text != "[Name]" || buttons[1].ButtonValue != "alice" {
+		t.Fatalf("unexpected second button: %+v", buttons[1])
+	}
+}
+
+func TestPrintPlainSkipsFormExpansion(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 5
+PRINTPLAIN 50% off {A} %A%
+PRINTL
+PRINTPLAINFORM 50% off {A}
+PRINTL
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"50% off {A} %A%", "", "50% off 5", ""}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+	if out[0].NewLine {
+		t.Fatalf("PRINTPLAIN should not end the line: %+v", out[0])
+	}
+}
+
+func TestLoadCharaRejectsUnknownSnapshotFormat(t *testing.T) {
+	tmp := t.TempDir()
+	future := `{"format":"erago.chara.v9","characters":[{"id":7,"vars":{}}]}`
+	legacy := `{"characters":[{"id":7,"vars":{}}]}`
+	if err := os.WriteFile(filepath.Join(tmp, "chara_future.dat"), []byte(future), 0o644); err != nil {
+		t.Fatalf("write future snapshot: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "chara_legacy.dat"), []byte(legacy), 0o644); err != nil {
+		t.Fatalf("write legacy snapshot: %v", err)
+	}
+	run := func(name string) ([]eruntime.Output, error) {
+		vm, err := erago.Compile(map[string]string{
+			"MAIN.ERB": fmt.Sprintf("\n@TITLE\nLOADCHARA %q\nPRINTVL CHARANUM\nQUIT\n", name),
+		})
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		vm.SetSaveDir(tmp)
+		return vm.Run("TITLE")
+	}
+	if _, err := run("future"); err == nil || !strings.Contains(err.Error(), `unsupported chara snapshot format "erago.chara.v9"`) {
+		t.Fatalf("expected unsupported format error, got %v", err)
+	}
+	out, err := run("legacy")
+	if err != nil {
+		t.Fatalf("legacy snapshot should load: %v", err)
+	}
+	if len(out) != 1 || out[0].Text != "1" {
+		t.Fatalf("unexpected legacy LOADCHARA output: %+v", out)
+	}
+}
+
+func TestStateHashTracksState(t *testing.T) {
+	files := map[string]string{
+		"DIM.ERH": "#DIM SCORES, 5\n",
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 1
+SCORES:2 = 4
+LOCALS = "x"
+STATEHASH
+PRINTFORML %RESULTS%
+PRINTFORML %STATEHASH()%
+SCORES:3 = 0
+PRINTFORML %STATEHASH()%
+CFLAG:0:1 = 9
+PRINTFORML %STATEHASH()%
+QUIT
+`,
+	}
+	run := func() []eruntime.Output {
+		vm, err := erago.Compile(files)
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		out, err := vm.Run("TITLE")
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if len(out) != 4 {
+			t.Fatalf("unexpected outputs: %+v", out)
+		}
+		if h := vm.StateHash(); h != out[3].Text {
+			t.Fatalf("host hash %q differs from script hash %q", h, out[3].Text)
+		}
+		return out
+	}
+	first, second := run(), run()
+	for i := range first {
+		if first[i].Text != second[i].Text {
+			t.Fatalf("hash %d not stable across runs: %q vs %q", i, first[i].Text, second[i].Text)
+		}
+	}
+	if len(first[0].Text) != 16 {
+		t.Fatalf("unexpected hash format: %q", first[0].Text)
+	}
+	if first[0].Text != first[1].Text || first[1].Text != first[2].Text {
+		t.Fatalf("hash changed without a state change: %q %q %q", first[0].Text, first[1].Text, first[2].Text)
+	}
+	if first[3].Text == first[2].Text {
+		t.Fatalf("hash did not change after mutating a character")
+	}
+}
+
+func TestStateHashIgnoresResetValues(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 1
+PRINTFORML %STATEHASH()%
+FLAG:3 = 5
+FLAG:3 = 0
+CFLAG:0:3 = 5
+CFLAG:0:3 = 0
+A = 7
+A = 0
+PRINTFORML %STATEHASH()%
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("unexpected outputs: %+v", out)
+	}
+	if out[0].Text != out[1].Text {
+		t.Fatalf("hash changed after values were reset: %q vs %q", out[0].Text, out[1].Text)
+	}
+}
+
+func TestSelectCaseStringRanges(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+FOR I, 0, 4
+	SELECTCASE I
+		CASE 0
+			LOCALS = "apple"
+		CASE 1
+			LOCALS = "pear"
+		CASE 2
+			LOCALS = "zebra"
+		CASEELSE
+			LOCALS = "m"
+	ENDSELECT
+	SELECTCASE LOCALS
+		CASE "a" TO "m"
+			PRINTFORML %LOCALS%:first
+		CASE IS >= "t"
+			PRINTFORML %LOCALS%:last
+		CASEELSE
+			PRINTFORML %LOCALS%:middle
+	ENDSELECT
+NEXT
+SELECTCASE 5
+	CASE 1 TO 9
+		PRINTL numeric
+ENDSELECT
+SELECTCASE 1.95
+	CASE 1 TO 1.9
+		PRINTL truncated
+	CASE IS < 1.5
+		PRINTL truncated
+	CASE IS < 2
+		PRINTL float
+ENDSELECT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"apple:first", "pear:middle", "zebra:last", "m:first", "numeric", "float"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestSelectCaseAndConditions(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+FOR I, 0, 12, 3
+	SELECTCASE I
+		CASE & IS >= 3, IS <= 6
+			PRINTFORML {I}:mid
+		CASE IS >= 3, IS <= 6
+			PRINTFORML {I}:edge
+	ENDSELECT
+NEXT
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"0:edge", "3:mid", "6:mid", "9:edge"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestFormAtTwoBranchConditional(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 1
+PRINTFORML [\@ A \@ on \@ off \@]
+PRINTFORML [\@ A == 0 \@ on \@ off {A} \@] tail
+PRINTFORML [\@ A ? yes # no \@]
+PRINTFORML [\@A\@on\@off\@]
+B = 2
+PRINTFORML \@A\@/\@B\@
+PRINTFORML \@ A \@ / \@ B \@
+PRINTFORML \@A\@ / \@B\@ / \@A + B\@
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"[on]", "[off 1] tail", "[yes]", "[on]", "1/2", "1 / 2", "1 / 2 / 3"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestPrintFormCSharesColumnRow(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTCPERLINE 3
+A = 7
+ALIGNMENT "RIGHT"
+PRINTFORMLC n={A}
+PRINTLC plain
+PRINTFORMC %"x"%{A}
+PRINTFORMC last
+PRINTL tail
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	cell := func(s string, right bool) string {
+		pad := strings.Repeat(" ", 27-len(s))
+		if right {
+			return pad + s + " "
+		}
+		return s + pad
+	}
+	expect := []eruntime.Output{
+		{Text: cell("n=7", false)},
+		{Text: cell("plain", false)},
+		{Text: cell("x7", true), NewLine: true},
+		{Text: cell("last", true)},
+		{NewLine: true},
+		{Text: "tail", NewLine: true},
+	}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i].Text || out[i].NewLine != expect[i].NewLine {
+			t.Fatalf("unexpected output at %d: got=%+v want=%+v", i, out[i], expect[i])
+		}
+	}
+}
+
+func TestColumnRowEndsBeforeOtherOutput(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTCPERLINE 3
+PRINTC a
+CUSTOMDRAWLINE =
+HTML_PRINT <b>x</b>
+PRINTC b
+CALL DONE
+PRINTC c
+QUIT
+
+@DONE
+PRINTDATAL
+    DATA data
+ENDDATA
+RETURN 0
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	cell := func(s string) string { return s + strings.Repeat(" ", 27-len(s)) }
+	expect := []eruntime.Output{
+		{Text: cell("a")},
+		{NewLine: true},
+		{Text: strings.Repeat("=", 81), NewLine: true},
+		{Text: "x", NewLine: true},
+		{Text: cell("b")},
+		{NewLine: true},
+		{Text: "data", NewLine: true},
+		{Text: cell("c")},
+		{NewLine: true},
+	}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i].Text || out[i].NewLine != expect[i].NewLine {
+			t.Fatalf("unexpected output at %d: got=%+v want=%+v", i, out[i], expect[i])
+		}
+	}
+}
+
+func TestSelectCharaAddressesImplicitCharacter(t *testing.T) {
+	files := map[string]string{
+		"Abl.csv":    "0,힘\n",
+		"Chara1.csv": "번호,1\n이름,A\n",
+		"Chara2.csv": "번호,2\n이름,B\n",
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 1
+ADDCHARA 2
+ABL:1:힘 = 5
+SELECTCHARA 1
+PRINTFORML {ABL:힘}
+ABL:힘 = 8
+ABL:힘++
+PRINTFORML {ABL:0:힘},{ABL:1:힘}
+SELECTCHARA
+PRINTFORML {RESULT},{ABL:힘}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"5", "0,9", "1,0"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestSelectCharaFollowsRosterChanges(t *testing.T) {
+	files := map[string]string{
+		"Abl.csv":    "0,힘\n",
+		"Chara1.csv": "번호,1\n이름,A\n",
+		"Chara2.csv": "번호,2\n이름,B\n",
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 2
+ADDCHARA 1
+ADDCHARA 2
+ABL:0:힘 = 10
+ABL:1:힘 = 20
+ABL:2:힘 = 30
+SELECTCHARA 1
+DELCHARA 0
+PRINTFORML {ABL:힘}
+SWAPCHARA 0, 1
+PRINTFORML {ABL:힘},{ABL:0:힘}
+SORTCHARA
+PRINTFORML {ABL:힘},{ABL:1:힘}
+DELCHARA 0
+SELECTCHARA
+PRINTFORML {RESULT}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"20", "20,30", "20,30", "-1"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestPrintBraceBlockText(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+A = 3
+PRINTFORML {
+	Day {A}
+	50%"!"%
+}
+PRINTL
+{
+PRINTFORML joined
+ line
+}
+PRINTL {
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"Day 3\n50!", "", "joinedline", "{"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestReloadCSVKeepsUnsetGameBaseFields(t *testing.T) {
+	files := map[string]string{
+		"CSV/Abl.csv":      "0,Skill\n",
+		"CSV/GameBase.csv": "TITLE,Old\nAUTHOR,Me\n",
+		"MAIN.ERB": `
+@TITLE
+PRINTFORML %ABLNAME:0%/%GAMEBASE_TITLE%/%GAMEBASE_AUTHOR%
+RELOADCSV
+PRINTFORML %ABLNAME:0%/%GAMEBASE_TITLE%/%GAMEBASE_AUTHOR%
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if _, err := vm.Run("TITLE"); err == nil || !strings.Contains(err.Error(), "no CSV source") {
+		t.Fatalf("expected RELOADCSV without a source to fail, got %v", err)
+	}
+	if err := vm.ReloadCSV(map[string]string{"MAIN.ERB": "@TITLE\n"}); err == nil {
+		t.Fatalf("expected ReloadCSV without CSV files to fail")
+	}
+	vm.SetCSVSource(func() (map[string]string, error) {
+		return map[string]string{
+			"CSV/Abl.csv":      "0,Power\n",
+			"CSV/GameBase.csv": "TITLE,New\nAUTHOR,\n",
+		}, nil
+	})
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"Skill/Old/Me", "Power/New/Me"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+	vm.SetCSVSource(func() (map[string]string, error) {
+		return nil, errors.New("disk unavailable")
+	})
+	if _, err := vm.Run("TITLE"); err == nil || !strings.Contains(err.Error(), "disk unavailable") {
+		t.Fatalf("expected source error to surface, got %v", err)
+	}
+}
+
+func TestResetCharaKeepsGlobals(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+FLAG:3 = 7
+ADDCHARA 0
+ADDCHARA 1
+PRINTFORML {CHARANUM}
+RESETCHARA
+PRINTFORML {CHARANUM}/{FLAG:3}/{RESULT}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"2", "0/7/1"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestCommentsAreStripped(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+; comment-only line
+A = 1 ; set flag
+S = ";" ; quoted semicolon survives
+;!;PRINTL emuera only
+PRINTFORML {A}%S%
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"emuera only", "1;"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestNestedSkipBlocks(t *testing.T) {
+	files := map[string]string{
+		"MAIN.ERB": `
+@TITLE
+PRINTL before
+[SKIPSTART]
+PRINTL outer
+[SKIPSTART]
+this is not a statement
+[SKIPEND]
+still skipped
+[SKIPEND]
+PRINTL after
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"before", "after"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
+
+func TestGetVarResolvesCharacterStatNames(t *testing.T) {
+	files := map[string]string{
+		"CSV/Abl.csv": "0,기교\n1,힘\n",
+		"MAIN.ERB": `
+@TITLE
+ADDCHARA 0
+ABL:0:힘 = 3
+PRINTFORML {GETVAR("ABL:0:힘")}
+SETVAR "ABL:0:힘", 5
+PRINTFORML {ABL:0:1}/{GETVAR("ABL:0", 1)}
+QUIT
+`,
+	}
+	vm, err := erago.Compile(files)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err := vm.Run("TITLE")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	expect := []string{"3", "5/5"}
+	if len(out) != len(expect) {
+		t.Fatalf("unexpected output count: %d (%+v)", len(out), out)
+	}
+	for i := range expect {
+		if out[i].Text != expect[i] {
+			t.Fatalf("unexpected output at %d: got=%q want=%q", i, out[i].Text, expect[i])
+		}
+	}
+}
@@ -8,10 +8,18 @@ import (
 )
 
 func parseDimDecl(raw string, isString bool, defaultScope string) (ast.VarDecl, bool) {
-	raw = strings.TrimSpace(raw)
+	raw, initRaw := splitDimDeclAndInit(raw)
 	if raw == "" {
 		return ast.VarDecl{}, false
 	}
+	var init []ast.Expr
+	if initRaw != "" {
+		for _, p := range splitTopLevel(initRaw, ',') {
+			if p = strings.TrimSpace(p); p != "" {
+				init = append(init, parseDimInitExpr(p))
+			}
+		}
+	}
 	parts := splitTopLevel(raw, ',')
 	if len(parts) == 0 {
 		return ast.VarDecl{}, false
@@ -28,12 +36,16 @@ func parseDimDecl(raw string, isString bool, defaultScope string) (ast.VarDecl,
 	}
 	isRef := false
 	isDynamic := false
+	noData := false
 
 	for _, f := range headFields[:len(headFields)-1] {
 		u := strings.ToUpper(strings.TrimSpace(f))
 		switch u {
 		case "GLOBAL", "SAVEDATA", "CHARADATA":
 			scope = "global"
+			noData = false
+		case "NODATA":
+			noData = true
 		case "LOCAL":
 			scope = "local"
 		case "DYNAMIC":
@@ -66,7 +78,7 @@ func parseDimDecl(raw string, isString bool, defaultScope string) (ast.VarDecl,
 		dims = append(dims, n)
 	}
 	if len(dims) == 0 {
-		dims = []int{1}
+		dims = []int{max(1, len(init))}
 	}
 
 	return ast.VarDecl{
@@ -76,5 +88,7 @@ func parseDimDecl(raw string, isString bool, defaultScope string) (ast.VarDecl,
 		Scope:     scope,
 		IsRef:     isRef,
 		IsDynamic: isDynamic,
+		NoData:    noData,
+		Init:      init,
 	}, true
 }
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -27,6 +28,17 @@ func isEventFunction(name string) bool {
 }
 
 func ParseERB(files map[string]string, macros map[string]struct{}) (*ERBResult, error) {
+	result, errs := parseERB(files, macros, false)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return result, nil
+}
+
+// parseERB parses every ERB file. In lenient mode a failing function is
+// recorded and skipped up to the next "@" line instead of aborting.
+func parseERB(files map[string]string, macros map[string]struct{}, lenient bool) (*ERBResult, []error) {
+	var errs []error
 	result := &ERBResult{
 		Functions:      map[string]*ast.Function{},
 		Order:          []string{},
@@ -36,11 +48,21 @@ func ParseERB(files map[string]string, macros map[string]struct{}) (*ERBResult,
 		lines := preprocess(toLines(file, files[file]), macros)
 		for i := 0; i < len(lines); {
 			if !strings.HasPrefix(lines[i].Content, "@") {
-				return nil, fmt.Errorf("%s:%d: expected function definition, got %q", lines[i].File, lines[i].Number, lines[i].Content)
+				errs = append(errs, fmt.Errorf("%s:%d: expected function definition, got %q", lines[i].File, lines[i].Number, lines[i].Content))
+				if !lenient {
+					return nil, errs
+				}
+				i = nextFunctionLine(lines, i+1)
+				continue
 			}
 			fn, consumed, err := parseFunction(lines, i)
 			if err != nil {
-				return nil, err
+				errs = append(errs, err)
+				if !lenient {
+					return nil, errs
+				}
+				i = nextFunctionLine(lines, i+1)
+				continue
 			}
 			// Event functions are tracked separately and called in order
 			if isEventFunction(fn.Name) {
@@ -55,7 +77,10 @@ func ParseERB(files map[string]string, macros map[string]struct{}) (*ERBResult,
 			}
 			if existing, exists := result.Functions[fn.Name]; exists {
 				if err := mergeDuplicateFunction(existing, fn); err != nil {
-					return nil, fmt.Errorf("%s:%d: duplicate function %s: %w", lines[i].File, lines[i].Number, fn.Name, err)
+					errs = append(errs, fmt.Errorf("%s:%d: duplicate function %s: %w", lines[i].File, lines[i].Number, fn.Name, err))
+					if !lenient {
+						return nil, errs
+					}
 				}
 				i += consumed
 				continue
@@ -70,18 +95,23 @@ func ParseERB(files map[string]string, macros map[string]struct{}) (*ERBResult,
 		sortEventFunctions(fns)
 		result.EventFunctions[name] = fns
 	}
-	return result, nil
+	return result, errs
 }
 
-// sortEventFunctions sorts functions by priority (higher priority first)
-func sortEventFunctions(fns []*ast.Function) {
-	for i := 0; i < len(fns)-1; i++ {
-		for j := i + 1; j < len(fns); j++ {
-			if fns[j].Priority > fns[i].Priority {
-				fns[i], fns[j] = fns[j], fns[i]
-			}
-		}
+// nextFunctionLine returns the index of the first "@" line at or after from.
+func nextFunctionLine(lines []Line, from int) int {
+	for from < len(lines) && !strings.HasPrefix(lines[from].Content, "@") {
+		from++
 	}
+	return from
+}
+
+// sortEventFunctions sorts functions by priority (higher priority first),
+// keeping file order among handlers of equal priority.
+func sortEventFunctions(fns []*ast.Function) {
+	sort.SliceStable(fns, func(i, j int) bool {
+		return fns[i].Priority > fns[j].Priority
+	})
 }
 
 func mergeDuplicateFunction(dst, src *ast.Function) error {
@@ -107,6 +137,10 @@ func mergeDuplicateFunction(dst, src *ast.Function) error {
 		return nil
 	}
 	offset := len(dst.Body.Statements)
+	if len(dst.Body.Positions) < offset {
+		dst.Body.Positions = append(dst.Body.Positions, make([]ast.Pos, offset-len(dst.Body.Positions))...)
+	}
+	dst.Body.Positions = append(dst.Body.Positions[:offset], src.Body.Positions...)
 	dst.Body.Statements = append(dst.Body.Statements, src.Body.Statements...)
 	for name, idx := range src.Body.LabelMap {
 		dst.Body.LabelMap[name] = idx + offset
@@ -131,10 +165,12 @@ func parseFunction(lines []Line, from int) (*ast.Function, int, error) {
 			priority = 1
 		} else if strings.HasPrefix(upper, "DIMS ") {
 			if decl, ok := parseDimDecl(prop[len("DIMS"):], true, "local"); ok {
+				decl.File, decl.Line = lines[idx].File, lines[idx].Number
 				varDecls = append(varDecls, decl)
 			}
 		} else if strings.HasPrefix(upper, "DIM ") {
 			if decl, ok := parseDimDecl(prop[len("DIM"):], false, "local"); ok {
+				decl.File, decl.Line = lines[idx].File, lines[idx].Number
 				varDecls = append(varDecls, decl)
 			}
 		}
@@ -221,6 +257,7 @@ func parseArgs(raw string) ([]ast.Arg, error) {
 
 func parseThunk(lines []Line, from int, until func(string) bool) (*ast.Thunk, int, error) {
 	stmts := make([]ast.Statement, 0, len(lines)-from)
+	positions := make([]ast.Pos, 0, len(lines)-from)
 	labels := map[string]int{}
 	idx := from
 	for idx < len(lines) {
@@ -242,9 +279,10 @@ func parseThunk(lines []Line, from int, until func(string) bool) (*ast.Thunk, in
 			return nil, 0, err
 		}
 		stmts = append(stmts, stmt)
+		positions = append(positions, ast.Pos{File: lines[idx].File, Line: lines[idx].Number})
 		idx += consumed
 	}
-	return &ast.Thunk{Statements: stmts, LabelMap: labels}, idx - from, nil
+	return &ast.Thunk{Statements: stmts, LabelMap: labels, Positions: positions}, idx - from, nil
 }
 
 func parseStatement(lines []Line, index int) (ast.Statement, int, error) {
@@ -354,6 +392,7 @@ func parseStatement(lines []Line, index int) (ast.Statement, int, error) {
 		body := &ast.Thunk{
 			Statements: []ast.Statement{nextStmt},
 			LabelMap:   map[string]int{},
+			Positions:  []ast.Pos{{File: lines[nextIdx].File, Line: lines[nextIdx].Number}},
 		}
 		return ast.IfStmt{
 			Branches: []ast.IfBranch{{Cond: cond, Body: body}},
@@ -600,6 +639,14 @@ func parseSelectCase(lines []Line, from int, rest string) (ast.Statement, int, e
 		switch {
 		case strings.HasPrefix(upper, "CASE "):
 			condRaw := strings.TrimSpace(line.Content[len("CASE"):])
+			// A trailing comma marks the branch as falling through to the
+			// next CASE body (or CASEELSE) once its own body finishes.
+			falls := strings.HasSuffix(condRaw, ",")
+			// A leading "&" ANDs the conditions instead of ORing them.
+			matchAll := strings.HasPrefix(condRaw, "&")
+			if matchAll {
+				condRaw = strings.TrimSpace(condRaw[1:])
+			}
 			conds, err := parseCaseConditions(condRaw)
 			if err != nil {
 				return nil, 0, fmt.Errorf("%s:%d: %w", line.File, line.Number, err)
@@ -613,8 +660,10 @@ func parseSelectCase(lines []Line, from int, rest string) (ast.Statement, int, e
 			}
 			idx += consumed
 			branches = append(branches, ast.SelectCaseBranch{
-				Conditions: conds,
-				Body:       body,
+				Conditions:  conds,
+				Body:        body,
+				Fallthrough: falls,
+				MatchAll:    matchAll,
 			})
 		case upper == "CASEELSE":
 			idx++
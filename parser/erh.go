@@ -47,6 +47,7 @@ func ParseERH(files map[string]string, macros map[string]struct{}) (*ERHResult,
 								decl.Dims = []int{max(1, len(splitTopLevel(initPart, ',')))}
 							}
 						}
+						decl.File, decl.Line = line.File, line.Number
 						result.StringVars[strings.ToUpper(decl.Name)] = struct{}{}
 						result.VarDecls = append(result.VarDecls, decl)
 					}
@@ -65,6 +66,7 @@ func ParseERH(files map[string]string, macros map[string]struct{}) (*ERHResult,
 								decl.Dims = []int{max(1, len(splitTopLevel(initPart, ',')))}
 							}
 						}
+						decl.File, decl.Line = line.File, line.Number
 						result.VarDecls = append(result.VarDecls, decl)
 					}
 					continue
@@ -147,16 +149,19 @@ func addDimInitializers(dst map[string]ast.Expr, name, initRaw string) error {
 		if p == "" {
 			continue
 		}
-		expr, err := ParseExpr(p)
-		if err != nil {
-			// keep compatibility with old parser behavior for bare numerics/strings
-			if n, convErr := strconv.ParseInt(p, 10, 64); convErr == nil {
-				expr = ast.IntLit{Value: n}
-			} else {
-				expr = ast.StringLit{Value: strings.Trim(p, "\"")}
-			}
-		}
-		dst[fmt.Sprintf("%s:%d", name, i)] = expr
+		dst[fmt.Sprintf("%s:%d", name, i)] = parseDimInitExpr(p)
 	}
 	return nil
 }
+
+func parseDimInitExpr(p string) ast.Expr {
+	expr, err := ParseExpr(p)
+	if err != nil {
+		// keep compatibility with old parser behavior for bare numerics/strings
+		if n, convErr := strconv.ParseInt(p, 10, 64); convErr == nil {
+			return ast.IntLit{Value: n}
+		}
+		return ast.StringLit{Value: strings.Trim(p, "\"")}
+	}
+	return expr
+}
@@ -14,6 +14,7 @@ type tokenKind int
 const (
 	tokEOF tokenKind = iota
 	tokInt
+	tokFloat
 	tokString
 	tokIdent
 	tokLParen
@@ -119,6 +120,9 @@ type exprParser struct {
 	tokens []token
 	pos    int
 	depth  int
+	// inIndex is set while parsing one index of a variable reference, so
+	// the colons of ABL:TARGET:2 stay on ABL instead of indexing TARGET.
+	inIndex bool
 }
 
 func (p *exprParser) peek() token {
@@ -145,6 +149,7 @@ func (p *exprParser) parse(minPrec int) (ast.Expr, error) {
 	if err != nil {
 		return nil, err
 	}
+	prevRelational := ""
 	for {
 		tok := p.peek()
 		if tok.kind != tokOp {
@@ -155,11 +160,20 @@ func (p *exprParser) parse(minPrec int) (ast.Expr, error) {
 			break
 		}
 		op := p.next().lit
+		// `a < b < c` would compare the 0/1 result of `a < b` with c;
+		// reject it unless the first comparison is parenthesized.
+		if isRelationalOp(op) && prevRelational != "" {
+			return nil, fmt.Errorf("chained comparison %q then %q; use parentheses or && to group explicitly", prevRelational, op)
+		}
 		right, err := p.parse(prec + 1)
 		if err != nil {
 			return nil, err
 		}
 		left = ast.BinaryExpr{Op: op, Left: left, Right: right}
+		prevRelational = ""
+		if isRelationalOp(op) {
+			prevRelational = op
+		}
 	}
 	if minPrec <= 1 && p.peek().kind == tokQuestion {
 		p.next()
@@ -180,6 +194,14 @@ func (p *exprParser) parse(minPrec int) (ast.Expr, error) {
 	return left, nil
 }
 
+func isRelationalOp(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
 func (p *exprParser) parsePrefix() (ast.Expr, error) {
 	t := p.next()
 	switch t.kind {
@@ -189,11 +211,20 @@ func (p *exprParser) parsePrefix() (ast.Expr, error) {
 			return nil, fmt.Errorf("invalid integer %q", t.lit)
 		}
 		return ast.IntLit{Value: v}, nil
+	case tokFloat:
+		v, err := strconv.ParseFloat(t.lit, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.lit)
+		}
+		return ast.FloatLit{Value: v}, nil
 	case tokString:
 		return ast.StringLit{Value: t.lit}, nil
 	case tokIdent:
 		if p.peek().kind == tokLParen {
 			p.next()
+			inIndex := p.inIndex
+			p.inIndex = false
+			defer func() { p.inIndex = inIndex }()
 			args := []ast.Expr{}
 			if p.peek().kind != tokRParen {
 				for {
@@ -224,9 +255,11 @@ func (p *exprParser) parsePrefix() (ast.Expr, error) {
 			return ast.CallExpr{Name: strings.ToUpper(t.lit), Args: args}, nil
 		}
 		ref := ast.VarRef{Name: strings.ToUpper(t.lit), Index: nil}
-		for p.peek().kind == tokColon {
+		for !p.inIndex && p.peek().kind == tokColon {
 			p.next()
+			p.inIndex = true
 			idxExpr, err := p.parse(11)
+			p.inIndex = false
 			if err != nil {
 				return nil, fmt.Errorf("invalid index expression for %s: %w", ref.Name, err)
 			}
@@ -238,7 +271,10 @@ func (p *exprParser) parsePrefix() (ast.Expr, error) {
 		}
 		return ref, nil
 	case tokLParen:
+		inIndex := p.inIndex
+		p.inIndex = false
 		e, err := p.parse(1)
+		p.inIndex = inIndex
 		if err != nil {
 			return nil, err
 		}
@@ -358,6 +394,15 @@ func tokenizeExpr(raw string) ([]token, error) {
 				i = k
 				continue
 			}
+			if j+1 < len(r) && r[j] == '.' && unicode.IsDigit(r[j+1]) {
+				k := j + 2
+				for k < len(r) && unicode.IsDigit(r[k]) {
+					k++
+				}
+				toks = append(toks, token{kind: tokFloat, lit: string(r[i:k])})
+				i = k
+				continue
+			}
 			toks = append(toks, token{kind: tokInt, lit: string(r[i:j])})
 			i = j
 			continue
@@ -9,6 +9,7 @@ var knownCommands = map[string]struct{}{
 	"ADDVOIDCHARA":        {},
 	"ALIGNMENT":           {},
 	"ALLSAMES":            {},
+	"ARGC":                {},
 	"ARRAYCOPY":           {},
 	"ARRAYREMOVE":         {},
 	"ARRAYSHIFT":          {},
@@ -23,7 +24,9 @@ var knownCommands = map[string]struct{}{
 	"BARL":                {},
 	"BARSTR":              {},
 	"BEGIN":               {},
+	"BEGINBATCH":          {},
 	"BREAK":               {},
+	"BYTESUBSTRING":       {},
 	"CALL":                {},
 	"CALLEVENT":           {},
 	"CALLF":               {},
@@ -36,14 +39,18 @@ var knownCommands = map[string]struct{}{
 	"CBGCLEAR":            {},
 	"CBGCLEARBUTTON":      {},
 	"CBGREMOVEBMAP":       {},
+	"CHARAEXISTS":         {},
+	"CHARANUM":            {},
 	"CHARATU":             {},
 	"CHKDATA":             {},
+	"CHKDATACOMPAT":       {},
 	"CHKFONT":             {},
 	"CLEARBIT":            {},
 	"CLEARLINE":           {},
 	"CLEARBGIMAGE":        {},
 	"CLEARTEXTBOX":        {},
 	"CMATCH":              {},
+	"COMPACTARRAY":        {},
 	"CONTINUE":            {},
 	"CONVERT":             {},
 	"COPYCHARA":           {},
@@ -52,8 +59,10 @@ var knownCommands = map[string]struct{}{
 	"CSVCALLNAME":         {},
 	"CSVCFLAG":            {},
 	"CSVCSTR":             {},
+	"CSVENUM":             {},
 	"CSVEQUIP":            {},
 	"CSVEXP":              {},
+	"CSVFILELIST":         {},
 	"CSVJUEL":             {},
 	"CSVMARK":             {},
 	"CSVMASTERNAME":       {},
@@ -61,6 +70,7 @@ var knownCommands = map[string]struct{}{
 	"CSVNICKNAME":         {},
 	"CSVRELATION":         {},
 	"CSVTALENT":           {},
+	"CSVTRAIN":            {},
 	"CUPCHECK":            {},
 	"CURRENTALIGN":        {},
 	"CURRENTREDRAW":       {},
@@ -77,6 +87,7 @@ var knownCommands = map[string]struct{}{
 	"DELALLCHARA":         {},
 	"DELCHARA":            {},
 	"DELDATA":             {},
+	"DELTATIME":           {},
 	"DO":                  {},
 	"DOTRAIN":             {},
 	"DRAWLINE":            {},
@@ -85,6 +96,7 @@ var knownCommands = map[string]struct{}{
 	"ELSE":                {},
 	"ELSEIF":              {},
 	"ENCODETOUNI":         {},
+	"ENDBATCH":            {},
 	"ENDCATCH":            {},
 	"ENDDATA":             {},
 	"ENDFUNC":             {},
@@ -108,16 +120,20 @@ var knownCommands = map[string]struct{}{
 	"FOR":                 {},
 	"FORCEKANA":           {},
 	"FORCEWAIT":           {},
+	"FORMWIDTH":           {},
 	"FUNC":                {},
 	"GETBGCOLOR":          {},
 	"GETBIT":              {},
 	"GETCHARA":            {},
+	"GETCHARADATA":        {},
+	"GETCHARAINDEX":       {},
 	"GETCOLOR":            {},
 	"GETDEFBGCOLOR":       {},
 	"GETDEFCOLOR":         {},
 	"GETEXPLV":            {},
 	"GETFOCUSCOLOR":       {},
 	"GETFONT":             {},
+	"GETLINECOUNT":        {},
 	"GETMILLISECOND":      {},
 	"GETNUM":              {},
 	"GETNUMB":             {},
@@ -126,6 +142,7 @@ var knownCommands = map[string]struct{}{
 	"GETSTYLE":            {},
 	"GETTIME":             {},
 	"GETTIMES":            {},
+	"GETWINDOWTITLE":      {},
 	"GOTO":                {},
 	"GOTOFORM":            {},
 	"GROUPMATCH":          {},
@@ -146,6 +163,7 @@ var knownCommands = map[string]struct{}{
 	"ISSKIP":              {},
 	"JUMP":                {},
 	"JUMPFORM":            {},
+	"LASTINPUT":           {},
 	"LIMIT":               {},
 	"LINEISEMPTY":         {},
 	"LOADCHARA":           {},
@@ -154,6 +172,7 @@ var knownCommands = map[string]struct{}{
 	"LOADGLOBAL":          {},
 	"LOADVAR":             {},
 	"LOOP":                {},
+	"MARKTIME":            {},
 	"MATCH":               {},
 	"MAX":                 {},
 	"MAXARRAY":            {},
@@ -221,6 +240,7 @@ var knownCommands = map[string]struct{}{
 	"PRINTFORMW":          {},
 	"PRINTFORMN":          {},
 	"PRINTFORMSN":         {},
+	"PRINTIMG":            {},
 	"PRINTK":              {},
 	"PRINTKL":             {},
 	"PRINTKW":             {},
@@ -281,17 +301,23 @@ var knownCommands = map[string]struct{}{
 	"PUTFORM":             {},
 	"PLAYBGM":             {},
 	"PLAYSOUND":           {},
+	"QUICKLOAD":           {},
+	"QUICKSAVE":           {},
 	"QUIT":                {},
 	"QUIT_AND_RESTART":    {},
 	"RANDOMIZE":           {},
+	"RANDSTREAM":          {},
 	"REDRAW":              {},
 	"REF":                 {},
 	"REFBYNAME":           {},
+	"REGEXPREPLACE":       {},
+	"RELOADCSV":           {},
 	"REND":                {},
 	"REPEAT":              {},
 	"REPLACE":             {},
 	"RESETBGCOLOR":        {},
 	"REMOVEBGIMAGE":       {},
+	"RESETCHARA":          {},
 	"RESETCOLOR":          {},
 	"RESETDATA":           {},
 	"RESETGLOBAL":         {},
@@ -308,6 +334,7 @@ var knownCommands = map[string]struct{}{
 	"SAVENOS":             {},
 	"SAVEVAR":             {},
 	"SELECTCASE":          {},
+	"SELECTCHARA":         {},
 	"SET":                 {},
 	"SETBGCOLOR":          {},
 	"SETBGCOLORBYNAME":    {},
@@ -315,16 +342,20 @@ var knownCommands = map[string]struct{}{
 	"SETBGMVOLUME":        {},
 	"SETSOUNDVOLUME":      {},
 	"SETBIT":              {},
+	"SETCHARADATA":        {},
 	"SETCOLOR":            {},
 	"SETCOLORBYNAME":      {},
 	"SETFONT":             {},
+	"SETWINDOWTITLE":      {},
 	"SIF":                 {},
 	"SIGN":                {},
+	"SIGNEDSTR":           {},
 	"SKIPDISP":            {},
 	"SKIPLOG":             {},
 	"SORTCHARA":           {},
 	"SPLIT":               {},
 	"SQRT":                {},
+	"STATEHASH":           {},
 	"STOPBGM":             {},
 	"STOPCALLTRAIN":       {},
 	"STOPSOUND":           {},
@@ -346,6 +377,9 @@ var knownCommands = map[string]struct{}{
 	"SUMCARRAY":           {},
 	"SWAP":                {},
 	"SWAPCHARA":           {},
+	"SYNCNO":              {},
+	"SYSINFO":             {},
+	"TABWIDTH":            {},
 	"THROW":               {},
 	"TIMES":               {},
 	"TINPUT":              {},
@@ -382,6 +416,7 @@ var knownCommands = map[string]struct{}{
 	"TWAIT":               {},
 	"UNICODE":             {},
 	"UPCHECK":             {},
+	"VARKIND":             {},
 	"VARSET":              {},
 	"VARSIZE":             {},
 	"WAIT":                {},
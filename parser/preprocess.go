@@ -31,6 +31,11 @@ func preprocess(lines []Line, macros map[string]struct{}) []Line {
 	out := make([]Line, 0, len(lines))
 	for _, l := range lines {
 		line := l
+		// ";!;" hides a line from eramaker but Emuera runs it, so unwrap it
+		// before the rest of the line is treated as a comment.
+		if after, ok := strings.CutPrefix(strings.TrimSpace(line.Content), ";!;"); ok {
+			line.Content = after
+		}
 		line.Content = stripComment(line.Content)
 		line.Content = strings.TrimSpace(line.Content)
 		if line.Content == "" {
@@ -74,13 +79,24 @@ func preprocess(lines []Line, macros map[string]struct{}) []Line {
 	return concatBraceLines(filtered)
 }
 
+// stripRange drops every line from a start marker through its matching end
+// marker. Nested start markers are counted, so an inner block's end marker
+// doesn't close the outer one.
 func stripRange(lines []Line, start, end string) []Line {
 	out := make([]Line, 0, len(lines))
 	for i := 0; i < len(lines); i++ {
 		if strings.EqualFold(lines[i].Content, start) {
+			depth := 1
 			j := i + 1
-			for j < len(lines) && !strings.EqualFold(lines[j].Content, end) {
-				j++
+			for ; j < len(lines); j++ {
+				if strings.EqualFold(lines[j].Content, start) {
+					depth++
+				} else if strings.EqualFold(lines[j].Content, end) {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
 			}
 			i = j
 			continue
@@ -90,9 +106,26 @@ func stripRange(lines []Line, start, end string) []Line {
 	return out
 }
 
+// concatBraceLines joins the lines between a "{" line and a "}" line into
+// one logical line. A PRINT/PRINTFORM line ending in "{" instead opens a
+// multiline text block that runs to the next "}" line.
 func concatBraceLines(lines []Line) []Line {
 	out := make([]Line, 0, len(lines))
 	for i := 0; i < len(lines); i++ {
+		if head, ok := blockTextPrintHead(lines[i].Content); ok {
+			if j, ok := blockTextEnd(lines, i+1); ok {
+				// Each block line stays a line of the printed text.
+				parts := make([]string, 0, j-i-1)
+				for _, l := range lines[i+1 : j] {
+					parts = append(parts, l.Content)
+				}
+				line := lines[i]
+				line.Content = head + " " + strings.Join(parts, `\n`)
+				out = append(out, line)
+				i = j
+				continue
+			}
+		}
 		if lines[i].Content != "{" {
 			out = append(out, lines[i])
 			continue
@@ -115,6 +148,38 @@ func concatBraceLines(lines []Line) []Line {
 	return out
 }
 
+// blockTextPrintHead reports whether content is a PRINT or PRINTFORM command
+// followed only by a separated "{", returning the command.
+func blockTextPrintHead(content string) (string, bool) {
+	rest, ok := strings.CutSuffix(content, "{")
+	if !ok {
+		return "", false
+	}
+	head := strings.TrimSpace(rest)
+	if len(head) == len(rest) {
+		return "", false
+	}
+	switch strings.ToUpper(head) {
+	case "PRINT", "PRINTL", "PRINTW", "PRINTFORM", "PRINTFORML", "PRINTFORMW":
+		return head, true
+	}
+	return "", false
+}
+
+// blockTextEnd finds the "}" line closing a text block that starts at from.
+// A "{" line first means the "{" was literal text, so no block is opened.
+func blockTextEnd(lines []Line, from int) (int, bool) {
+	for j := from; j < len(lines); j++ {
+		switch lines[j].Content {
+		case "}":
+			return j, j > from
+		case "{":
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
 func stripComment(raw string) string {
 	if raw == "" {
 		return raw
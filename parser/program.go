@@ -3,11 +3,29 @@ package parser
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/gosuda/erago/ast"
 )
 
 func ParseProgram(files map[string]string) (*ast.Program, error) {
+	prog, errs := parseProgram(files, false)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return prog, nil
+}
+
+// ParseProgramLenient parses like ParseProgram but keeps going past broken
+// functions, returning the functions that parsed together with every error
+// encountered. The program is nil only when no ERB files are present.
+func ParseProgramLenient(files map[string]string) (*ast.Program, []error) {
+	return parseProgram(files, true)
+}
+
+func parseProgram(files map[string]string, lenient bool) (*ast.Program, []error) {
+	start := time.Now()
+	var errs []error
 	erh := map[string]string{}
 	erb := map[string]string{}
 	csv := map[string]string{}
@@ -24,21 +42,27 @@ func ParseProgram(files map[string]string) (*ast.Program, error) {
 	}
 
 	if len(erb) == 0 {
-		return nil, fmt.Errorf("no ERB files found")
+		return nil, []error{fmt.Errorf("no ERB files found")}
 	}
 
 	macros := map[string]struct{}{}
 	erhRes, err := ParseERH(erh, macros)
 	if err != nil {
-		return nil, err
+		errs = append(errs, err)
+		if !lenient {
+			return nil, errs
+		}
+		erhRes = &ERHResult{Defines: map[string]ast.Expr{}, StringVars: map[string]struct{}{}}
+		macros = map[string]struct{}{}
 	}
 
-	res, err := ParseERB(erb, macros)
-	if err != nil {
-		return nil, err
+	res, erbErrs := parseERB(erb, macros, lenient)
+	errs = append(errs, erbErrs...)
+	if res == nil {
+		return nil, errs
 	}
 
-	return &ast.Program{
+	prog := &ast.Program{
 		Defines:        erhRes.Defines,
 		Functions:      res.Functions,
 		Order:          res.Order,
@@ -46,5 +70,53 @@ func ParseProgram(files map[string]string) (*ast.Program, error) {
 		StringVars:     erhRes.StringVars,
 		VarDecls:       erhRes.VarDecls,
 		EventFunctions: res.EventFunctions,
-	}, nil
+	}
+	prog.Stats = programStats(prog)
+	prog.Stats.ParseDuration = time.Since(start)
+	return prog, errs
+}
+
+func programStats(prog *ast.Program) ast.ProgramStats {
+	var stats ast.ProgramStats
+	for _, name := range prog.Order {
+		fns := prog.EventFunctions[name]
+		if len(fns) == 0 {
+			fns = []*ast.Function{prog.Functions[name]}
+		}
+		for _, fn := range fns {
+			stats.Functions++
+			stats.Statements += countStatements(fn.Body)
+		}
+	}
+	return stats
+}
+
+func countStatements(t *ast.Thunk) int {
+	if t == nil {
+		return 0
+	}
+	n := len(t.Statements)
+	for _, stmt := range t.Statements {
+		switch s := stmt.(type) {
+		case ast.IfStmt:
+			for _, b := range s.Branches {
+				n += countStatements(b.Body)
+			}
+			n += countStatements(s.Else)
+		case ast.SelectCaseStmt:
+			for _, b := range s.Branches {
+				n += countStatements(b.Body)
+			}
+			n += countStatements(s.Else)
+		case ast.WhileStmt:
+			n += countStatements(s.Body)
+		case ast.DoWhileStmt:
+			n += countStatements(s.Body)
+		case ast.RepeatStmt:
+			n += countStatements(s.Body)
+		case ast.ForStmt:
+			n += countStatements(s.Body)
+		}
+	}
+	return n
 }
@@ -85,7 +85,50 @@ func (a *ArrayVar) Set(index []int64, v Value) error {
 	if a.IsString {
 		a.Data[k] = Str(v.String())
 	} else {
-		a.Data[k] = Int(v.Int64())
+		a.Data[k] = numericValue(v)
 	}
 	return nil
 }
+
+// isNamedKey reports whether key can address an element by name. Keys that
+// parse as an integer index would alias a numeric element, so they are not
+// names.
+func isNamedKey(key string) bool {
+	if strings.TrimSpace(key) == "" {
+		return false
+	}
+	_, isIndex := parseIndexKey(key)
+	return !isIndex
+}
+
+// GetKey reads the element stored under a string key.
+func (a *ArrayVar) GetKey(key string) Value {
+	if v, ok := a.Data[key]; ok {
+		return v
+	}
+	return a.defaultValue()
+}
+
+// SetKey stores v under a string key. Named elements share Data with the
+// indexed ones but are outside Dims, so dense binary saves leave them out.
+func (a *ArrayVar) SetKey(key string, v Value) {
+	if a.IsString {
+		a.Data[key] = Str(v.String())
+	} else {
+		a.Data[key] = numericValue(v)
+	}
+}
+
+// compact drops stored elements that equal the default value, which reads
+// return anyway, and reports how many were removed.
+func (a *ArrayVar) compact() int {
+	def := a.defaultValue()
+	removed := 0
+	for k, v := range a.Data {
+		if v.Kind() == def.Kind() && v.String() == def.String() {
+			delete(a.Data, k)
+			removed++
+		}
+	}
+	return removed
+}
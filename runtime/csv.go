@@ -2,6 +2,7 @@ package eruntime
 
 import (
 	"encoding/csv"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -20,6 +21,14 @@ type CSVStore struct {
 	gameYear       string
 	windowTitle    string
 	gameInfo       string
+	replaces       []csvReplace
+	files          []string
+	anyIDs         map[string]int64
+}
+
+type csvReplace struct {
+	from string
+	to   string
 }
 
 func newCSVStore(files map[string]string) *CSVStore {
@@ -38,6 +47,7 @@ func newCSVStore(files map[string]string) *CSVStore {
 		}
 		rows := parseCSVContent(content)
 		s.rowsByBase[base] = rows
+		s.files = append(s.files, csvFileName(file))
 		if id, ok := charaIDFromBase(base); ok {
 			s.charaExists[id] = struct{}{}
 			s.charaRowsByID[id] = rows
@@ -73,6 +83,14 @@ func newCSVStore(files map[string]string) *CSVStore {
 				}
 			}
 		}
+		if base == "_REPLACE" {
+			for _, row := range rows {
+				if len(row) < 2 || row[0] == "" {
+					continue
+				}
+				s.replaces = append(s.replaces, csvReplace{from: row[0], to: row[1]})
+			}
+		}
 		nameMap := map[int64]string{}
 		for _, row := range rows {
 			if len(row) < 2 {
@@ -87,9 +105,34 @@ func newCSVStore(files map[string]string) *CSVStore {
 		s.nameByBase[base] = nameMap
 		s.ingestCharacterNameRows(base, rows)
 	}
+	s.indexAnyIDs()
 	return s
 }
 
+// indexAnyIDs builds the upper-cased name to ID table behind FindAnyID,
+// leaving out names that map to different IDs in different tables.
+func (s *CSVStore) indexAnyIDs() {
+	s.anyIDs = map[string]int64{}
+	ambiguous := map[string]bool{}
+	for base, m := range s.nameByBase {
+		if base == "NAME" || base == "CALLNAME" {
+			continue
+		}
+		for id, name := range m {
+			key := strings.ToUpper(name)
+			if key == "" || ambiguous[key] {
+				continue
+			}
+			if prev, ok := s.anyIDs[key]; ok && prev != id {
+				delete(s.anyIDs, key)
+				ambiguous[key] = true
+				continue
+			}
+			s.anyIDs[key] = id
+		}
+	}
+}
+
 func (s *CSVStore) ingestCharacterNameRows(base string, rows [][]string) {
 	id, ok := charaIDFromBase(base)
 	if !ok {
@@ -189,6 +232,31 @@ func csvBaseName(file string) string {
 	return up
 }
 
+// csvFileName strips any directory from a CSV path, keeping the name as
+// it was spelled.
+func csvFileName(file string) string {
+	name := strings.TrimSpace(file)
+	if i := strings.LastIndexAny(name, "/\\"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// Files returns the names of the loaded CSV files in sorted order.
+func (s *CSVStore) Files() []string {
+	out := append([]string(nil), s.files...)
+	sort.Strings(out)
+	return out
+}
+
+// ApplyReplace substitutes the display tokens configured in _Replace.csv.
+func (s *CSVStore) ApplyReplace(text string) string {
+	for _, r := range s.replaces {
+		text = strings.ReplaceAll(text, r.from, r.to)
+	}
+	return text
+}
+
 func (s *CSVStore) Name(base string, id int64) (string, bool) {
 	base = strings.ToUpper(strings.TrimSpace(base))
 	m := s.nameByBase[base]
@@ -199,6 +267,23 @@ func (s *CSVStore) Name(base string, id int64) (string, bool) {
 	return v, ok
 }
 
+type CSVEntry struct {
+	ID   int64
+	Name string
+}
+
+// Entries returns every (id, name) pair of a CSV table ordered by ID.
+func (s *CSVStore) Entries(base string) []CSVEntry {
+	base = strings.ToUpper(strings.TrimSpace(base))
+	m := s.nameByBase[base]
+	out := make([]CSVEntry, 0, len(m))
+	for id, name := range m {
+		out = append(out, CSVEntry{ID: id, Name: name})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
 func (s *CSVStore) CharaField(id int64, section, key string) (string, bool) {
 	rows := s.charaRowsByID[id]
 	if len(rows) == 0 {
@@ -227,6 +312,74 @@ func (s *CSVStore) CharaField(id int64, section, key string) (string, bool) {
 	return "", false
 }
 
+// charaDefaultSections are the chara CSV sections copied into a new
+// character's Vars by CharaDefaults.
+var charaDefaultSections = []string{
+	"BASE", "TALENT", "ABL", "EXP", "MARK", "RELATION", "EQUIP", "CFLAG", "JUEL", "CSTR",
+}
+
+// CharaDefaults returns the initial character variables declared in the
+// chara CSV for id, keyed like RuntimeCharacter.Vars ("BASE:1"). BASE rows
+// also seed MAXBASE, and a TALENT row without a value sets the talent to 1.
+func (s *CSVStore) CharaDefaults(id int64) map[string]Value {
+	vars := map[string]Value{}
+	for _, row := range s.charaRowsByID[id] {
+		if len(row) < 2 {
+			continue
+		}
+		section := ""
+		for _, candidate := range charaDefaultSections {
+			if csvSectionMatches(candidate, row[0]) {
+				section = candidate
+				break
+			}
+		}
+		if section == "" {
+			continue
+		}
+		idx, ok := s.charaFieldIndex(section, row[1])
+		if !ok {
+			continue
+		}
+		raw := ""
+		if len(row) >= 3 {
+			raw = strings.TrimSpace(row[2])
+		}
+		key := section + ":" + strconv.FormatInt(idx, 10)
+		if section == "CSTR" {
+			vars[key] = Str(raw)
+			continue
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			if raw != "" || section != "TALENT" {
+				continue
+			}
+			n = 1
+		}
+		vars[key] = Int(n)
+		if section == "BASE" {
+			vars["MAXBASE:"+strconv.FormatInt(idx, 10)] = Int(n)
+		}
+	}
+	return vars
+}
+
+// charaFieldIndex resolves the index column of a chara CSV row, which may
+// be a number or a name from the section's own CSV (ABL.CSV and so on).
+func (s *CSVStore) charaFieldIndex(section, field string) (int64, bool) {
+	field = strings.TrimSpace(field)
+	if n, err := strconv.ParseInt(field, 10, 64); err == nil {
+		return n, true
+	}
+	for id, name := range s.nameByBase[section] {
+		if name == field {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
 func csvSectionMatches(section, actual string) bool {
 	actual = strings.TrimSpace(actual)
 	switch section {
@@ -244,6 +397,12 @@ func csvSectionMatches(section, actual string) bool {
 		return actual == "\u76F8\u6027" || strings.EqualFold(actual, "RELATION")
 	case "EQUIP":
 		return actual == "\u88C5\u7740\u7269" || strings.EqualFold(actual, "EQUIP")
+	case "MARK":
+		return actual == "\u523B\u5370" || strings.EqualFold(actual, "MARK")
+	case "CFLAG":
+		return actual == "\u30D5\u30E9\u30B0" || strings.EqualFold(actual, "CFLAG")
+	case "JUEL":
+		return actual == "\u73E0" || strings.EqualFold(actual, "JUEL")
 	default:
 		return strings.EqualFold(actual, section)
 	}
@@ -289,6 +448,13 @@ func (s *CSVStore) FindID(base, name string) (int64, bool) {
 	return 0, false
 }
 
+// FindAnyID looks a name up across every CSV table and only succeeds when
+// all tables that know the name agree on its ID.
+func (s *CSVStore) FindAnyID(name string) (int64, bool) {
+	id, ok := s.anyIDs[strings.ToUpper(strings.TrimSpace(name))]
+	return id, ok
+}
+
 func (s *CSVStore) GetCSVMap(name string) map[string]string {
 	name = strings.ToUpper(strings.TrimSpace(name))
 	m := s.nameByBase[name]
@@ -2,6 +2,7 @@ package eruntime
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -38,6 +39,10 @@ const (
 	eraTypeEOF      eraSaveDataType = 0xFF
 )
 
+// maxSaveArrayDims is the deepest array the Emuera binary format can
+// encode; SetDatSaveFormat rejects saved declarations beyond it.
+const maxSaveArrayDims = 3
+
 const (
 	eraBDHeader  uint64 = 0x0A1A0A0D41524589
 	eraBDVersion uint32 = 1808
@@ -61,6 +66,8 @@ const (
 type eraBinaryWriter struct {
 	f *os.File
 	w *bytes.Buffer
+	// compress gzips the finished buffer on Close.
+	compress bool
 }
 
 func newEraBinaryWriter(path string) (*eraBinaryWriter, error) {
@@ -78,12 +85,21 @@ func (bw *eraBinaryWriter) Close() error {
 	if bw.f == nil {
 		return nil
 	}
-	if _, err := bw.f.Write(bw.w.Bytes()); err != nil {
+	var err error
+	if bw.compress {
+		zw := gzip.NewWriter(bw.f)
+		if _, err = zw.Write(bw.w.Bytes()); err == nil {
+			err = zw.Close()
+		}
+	} else {
+		_, err = bw.f.Write(bw.w.Bytes())
+	}
+	if err != nil {
 		_ = bw.f.Close()
 		bw.f = nil
 		return err
 	}
-	err := bw.f.Close()
+	err = bw.f.Close()
 	bw.f = nil
 	return err
 }
@@ -382,7 +398,21 @@ type eraBinaryReader struct {
 	version uint32
 }
 
+// isGzipData reports whether b starts with the gzip magic bytes.
+func isGzipData(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b
+}
+
 func newEraBinaryReader(b []byte) (*eraBinaryReader, error) {
+	if isGzipData(b) {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		if b, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
 	r := bytes.NewReader(b)
 	var hdr uint64
 	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
@@ -892,8 +922,8 @@ func arrayToDenseInt(arr *ArrayVar) ([]int64, []int, error) {
 	if len(dims) == 0 {
 		dims = []int{1}
 	}
-	if len(dims) > 3 {
-		return nil, nil, fmt.Errorf("array dimension > 3 is not supported in binary save")
+	if len(dims) > maxSaveArrayDims {
+		return nil, nil, fmt.Errorf("array dimension > %d is not supported in binary save", maxSaveArrayDims)
 	}
 	total := 1
 	for _, d := range dims {
@@ -911,6 +941,9 @@ func arrayToDenseInt(arr *ArrayVar) ([]int64, []int, error) {
 	}
 	flat := make([]int64, total)
 	for k, v := range arr.Data {
+		if isNamedKey(k) {
+			return nil, nil, fmt.Errorf("named element %q is not supported in binary save", k)
+		}
 		idx, ok := parseIndexKey(k)
 		if !ok || len(idx) == 0 || len(idx) > len(dims) {
 			continue
@@ -944,8 +977,8 @@ func arrayToDenseStr(arr *ArrayVar) ([]string, []int, error) {
 	if len(dims) == 0 {
 		dims = []int{1}
 	}
-	if len(dims) > 3 {
-		return nil, nil, fmt.Errorf("array dimension > 3 is not supported in binary save")
+	if len(dims) > maxSaveArrayDims {
+		return nil, nil, fmt.Errorf("array dimension > %d is not supported in binary save", maxSaveArrayDims)
 	}
 	total := 1
 	for _, d := range dims {
@@ -963,6 +996,9 @@ func arrayToDenseStr(arr *ArrayVar) ([]string, []int, error) {
 	}
 	flat := make([]string, total)
 	for k, v := range arr.Data {
+		if isNamedKey(k) {
+			return nil, nil, fmt.Errorf("named element %q is not supported in binary save", k)
+		}
 		idx, ok := parseIndexKey(k)
 		if !ok || len(idx) == 0 || len(idx) > len(dims) {
 			continue
@@ -1067,6 +1103,17 @@ func denseStrToArray(flat []string, dims []int) *ArrayVar {
 }
 
 func IsEraBinaryData(data []byte) bool {
+	if isGzipData(data) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return false
+		}
+		head := make([]byte, 16)
+		if _, err := io.ReadFull(zr, head); err != nil {
+			return false
+		}
+		data = head
+	}
 	if len(data) < 16 {
 		return false
 	}
@@ -1078,6 +1125,7 @@ func (vm *VM) writeVarBinaryFile(path string, saveMes string, globals map[string
 	if err != nil {
 		return err
 	}
+	bw.compress = vm.saveCompression
 	bw.writeHeader()
 	bw.writeFileType(eraSaveVar)
 	bw.writeInt64(vm.saveUniqueCode)
@@ -1140,27 +1188,34 @@ func (vm *VM) writeVarBinaryFile(path string, saveMes string, globals map[string
 	return nil
 }
 
-func (vm *VM) readVarBinaryData(data []byte) (unique int64, version int64, saveMes string, globals map[string]Value, arrays map[string]*ArrayVar, err error) {
-	br, err := newEraBinaryReader(data)
-	if err != nil {
-		return 0, 0, "", nil, nil, err
-	}
+// readVarBinaryHeader reads the identity and save message that precede the
+// variable records of a var save.
+func readVarBinaryHeader(br *eraBinaryReader) (unique int64, version int64, saveMes string, err error) {
 	ft, err := br.readFileType()
 	if err != nil {
-		return 0, 0, "", nil, nil, err
+		return 0, 0, "", err
 	}
 	if ft != eraSaveVar {
-		return 0, 0, "", nil, nil, fmt.Errorf("not var save data")
+		return 0, 0, "", fmt.Errorf("not var save data")
 	}
-	unique, err = br.readInt64()
-	if err != nil {
-		return 0, 0, "", nil, nil, err
+	if unique, err = br.readInt64(); err != nil {
+		return 0, 0, "", err
 	}
-	version, err = br.readInt64()
+	if version, err = br.readInt64(); err != nil {
+		return 0, 0, "", err
+	}
+	if saveMes, err = br.readDotNetString(); err != nil {
+		return 0, 0, "", err
+	}
+	return unique, version, saveMes, nil
+}
+
+func (vm *VM) readVarBinaryData(data []byte) (unique int64, version int64, saveMes string, globals map[string]Value, arrays map[string]*ArrayVar, err error) {
+	br, err := newEraBinaryReader(data)
 	if err != nil {
 		return 0, 0, "", nil, nil, err
 	}
-	saveMes, err = br.readDotNetString()
+	unique, version, saveMes, err = readVarBinaryHeader(br)
 	if err != nil {
 		return 0, 0, "", nil, nil, err
 	}
@@ -1237,6 +1292,7 @@ func (vm *VM) writeCharaBinaryFile(path string, saveMes string, chars []RuntimeC
 	if err != nil {
 		return err
 	}
+	bw.compress = vm.saveCompression
 	bw.writeHeader()
 	bw.writeFileType(eraSaveCharVar)
 	bw.writeInt64(vm.saveUniqueCode)
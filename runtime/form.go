@@ -1,6 +1,7 @@
 package eruntime
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -13,9 +14,14 @@ func (vm *VM) evalPrintForm(arg string) (string, error) {
 	return vm.expandFormTemplate(tmpl)
 }
 
+// formExpansionLimit bounds how often placeholders are re-expanded while a
+// template's text keeps changing.
+const formExpansionLimit = 8
+
 func (vm *VM) expandFormTemplate(tmpl string) (string, error) {
 	out := tmpl
-	for i := 0; i < 8; i++ {
+	stable := false
+	for i := 0; i < formExpansionLimit; i++ {
 		prev := out
 		t, err := vm.evalPercentPlaceholders(out)
 		if err != nil {
@@ -33,10 +39,14 @@ func (vm *VM) expandFormTemplate(tmpl string) (string, error) {
 		}
 		out = t
 		if out == prev {
+			stable = true
 			break
 		}
 	}
-	return out, nil
+	if !stable && vm.strictForms {
+		return "", fmt.Errorf("form template %q did not stabilize after %d expansions", tmpl, formExpansionLimit)
+	}
+	return vm.csv.ApplyReplace(out), nil
 }
 
 func decodeCommandCharSeq(raw string) string {
@@ -170,19 +180,65 @@ func (vm *VM) evalPercentPlaceholderExpr(raw string) (string, bool, error) {
 	}
 	align := "RIGHT"
 	if len(parts) >= 3 {
-		alignRaw := strings.TrimSpace(parts[2])
-		if alignRaw != "" {
-			if av, err := vm.evalLooseExpr(alignRaw); err == nil {
-				align = strings.ToUpper(strings.TrimSpace(av.String()))
-			} else {
-				align = strings.ToUpper(strings.Trim(alignRaw, "\""))
-			}
+		align = vm.evalFieldAlign(parts[2], align)
+	}
+	fill := ' '
+	if len(parts) >= 4 {
+		fillRaw := strings.TrimSpace(parts[3])
+		if fv, err := vm.evalLooseExpr(fillRaw); err == nil {
+			fillRaw = fv.String()
+		} else {
+			fillRaw = strings.Trim(fillRaw, "\"")
+		}
+		if r := []rune(fillRaw); len(r) > 0 {
+			fill = r[0]
 		}
 	}
-	return formatPrintField(baseText, int(widthVal.Int64()), align), true, nil
+	return formatPrintFieldFill(baseText, int(widthVal.Int64()), align, fill), true, nil
+}
+
+// evalFieldAlign resolves the alignment argument of a form placeholder.
+// Keywords are matched before evaluation so LEFT is never read as a
+// variable; anything that does not name an alignment falls back to def.
+func (vm *VM) evalFieldAlign(raw, def string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return def
+	}
+	if align, ok := fieldAlignToken(raw); ok {
+		return align
+	}
+	if av, err := vm.evalLooseExpr(raw); err == nil {
+		if align, ok := fieldAlignToken(av.String()); ok {
+			return align
+		}
+		return def
+	}
+	if align, ok := fieldAlignToken(strings.Trim(raw, "\"")); ok {
+		return align
+	}
+	return def
+}
+
+// fieldAlignToken accepts LEFT, RIGHT, CENTER (MIDDLE) and their one-letter
+// shorthands L, R, C, case-insensitively.
+func fieldAlignToken(s string) (string, bool) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "LEFT", "L":
+		return "LEFT", true
+	case "RIGHT", "R":
+		return "RIGHT", true
+	case "CENTER", "MIDDLE", "C":
+		return "CENTER", true
+	}
+	return "", false
 }
 
 func formatPrintField(text string, width int, align string) string {
+	return formatPrintFieldFill(text, width, align, ' ')
+}
+
+func formatPrintFieldFill(text string, width int, align string, fill rune) string {
 	if width < 0 {
 		width = -width
 	}
@@ -193,14 +249,14 @@ func formatPrintField(text string, width int, align string) string {
 	if rlen >= width {
 		return text
 	}
-	pad := strings.Repeat(" ", width-rlen)
+	pad := strings.Repeat(string(fill), width-rlen)
 	switch align {
 	case "LEFT":
 		return text + pad
 	case "CENTER", "MIDDLE":
 		left := (width - rlen) / 2
 		right := width - rlen - left
-		return strings.Repeat(" ", left) + text + strings.Repeat(" ", right)
+		return strings.Repeat(string(fill), left) + text + strings.Repeat(string(fill), right)
 	default:
 		return pad + text
 	}
@@ -342,14 +398,7 @@ func (vm *VM) evalBracePlaceholderExpr(raw string) (string, bool, error) {
 	}
 	align := "RIGHT"
 	if len(parts) >= 3 {
-		alignRaw := strings.TrimSpace(parts[2])
-		if alignRaw != "" {
-			if av, err := vm.evalLooseExpr(alignRaw); err == nil {
-				align = strings.ToUpper(strings.TrimSpace(av.String()))
-			} else {
-				align = strings.ToUpper(strings.Trim(alignRaw, "\""))
-			}
-		}
+		align = vm.evalFieldAlign(parts[2], align)
 	}
 	return formatPrintField(baseVal.String(), int(widthVal.Int64()), align), true, nil
 }
@@ -374,6 +423,13 @@ func (vm *VM) evalAtPlaceholders(tmpl string) (string, error) {
 			i = j + 1
 			continue
 		}
+		if repl, end, ok, err := vm.evalAtTwoBranch(tmpl, i, j); err != nil {
+			return "", err
+		} else if ok {
+			b.WriteString(repl)
+			i = end + 1
+			continue
+		}
 		repl, handled, err := vm.evalAtPlaceholderExpr(exprRaw)
 		if err != nil {
 			return "", err
@@ -389,6 +445,53 @@ func (vm *VM) evalAtPlaceholders(tmpl string) (string, error) {
 	return b.String(), nil
 }
 
+// evalAtTwoBranch handles the @cond@true@false@ form, where open and condEnd
+// are the '@'s around cond; end is the index of the final '@'. Two plain
+// placeholders such as "@A@ / @B@" have the same delimiters, so the form is
+// not taken when its false text would itself be a placeholder: an
+// expression other than a bare unknown name, the same test evalAtBranch
+// uses to keep a branch as text. cond must have no ?# ternary of its own.
+func (vm *VM) evalAtTwoBranch(tmpl string, open, condEnd int) (string, int, bool, error) {
+	condRaw := strings.TrimSpace(tmpl[open+1 : condEnd])
+	if _, _, _, ok := splitTopLevelTernary(condRaw); ok {
+		return "", 0, false, nil
+	}
+	k, ok := findAtPlaceholderEnd(tmpl, condEnd+1)
+	if !ok {
+		return "", 0, false, nil
+	}
+	end, ok := findAtPlaceholderEnd(tmpl, k+1)
+	if !ok || vm.readsAsAtPlaceholder(tmpl[k+1:end]) {
+		return "", 0, false, nil
+	}
+	expr, err := parser.ParseExpr(condRaw)
+	if err != nil {
+		return "", 0, false, nil
+	}
+	cond, err := vm.evalExpr(expr)
+	if err != nil {
+		return "", 0, false, err
+	}
+	branch := tmpl[k+1 : end]
+	if cond.Truthy() {
+		branch = tmpl[condEnd+1 : k]
+	}
+	text, err := vm.evalAtBranch(branch)
+	if err != nil {
+		return "", 0, false, err
+	}
+	return text, end, true, nil
+}
+
+func (vm *VM) readsAsAtPlaceholder(seg string) bool {
+	expr, err := parser.ParseExpr(strings.TrimSpace(seg))
+	if err != nil {
+		return false
+	}
+	ref, ok := expr.(ast.VarRef)
+	return !ok || len(ref.Index) != 0 || vm.symbolExists(ref.Name)
+}
+
 func (vm *VM) evalAtPlaceholderExpr(raw string) (string, bool, error) {
 	condRaw, tRaw, fRaw, ok := splitTopLevelTernary(raw)
 	if ok {
@@ -433,6 +536,11 @@ func (vm *VM) evalAtBranch(raw string) (string, error) {
 		}
 		return v.String(), nil
 	}
+	// Quoted and @"..." verbatim branches are form text: expand their
+	// placeholders here rather than relying on a later template pass.
+	if uq, ok := tryUnquoteCommandString(raw); ok {
+		return vm.expandDecodedTemplate(uq)
+	}
 	if expr, err := parser.ParseExpr(raw); err == nil {
 		if ref, ok := expr.(ast.VarRef); ok && len(ref.Index) == 0 && !vm.symbolExists(ref.Name) {
 			return raw, nil
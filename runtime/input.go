@@ -24,6 +24,9 @@ type InputRequest struct {
 	HasDefault     bool
 	DefaultValue   Value
 	TimeoutMessage string
+	// Rejected holds the previous answer when a numeric INPUT is asked
+	// again because that answer was not a number.
+	Rejected string
 }
 
 type InputState struct {
@@ -71,12 +74,22 @@ func (vm *VM) beginInputRequest(req InputRequest) {
 }
 
 func (vm *VM) finishInputRequest(value string, timeout bool) {
-	vm.input.LastValue = value
+	// WAIT and its relatives only wait for a keypress, so LASTINPUT keeps
+	// the answer to the last INPUT-family request.
+	if vm.input.Current == nil || !isWaitCommand(vm.input.Current.Command) {
+		vm.input.LastValue = value
+	}
 	vm.input.LastTimeout = timeout
 	vm.input.Current = nil
 	vm.input.Phase = InputIdle
 }
 
+// isWaitCommand reports whether an input request only waits for a key
+// rather than reading a value.
+func isWaitCommand(name string) bool {
+	return isAny(name, "WAIT", "WAITANYKEY", "FORCEWAIT", "TWAIT", "AWAIT")
+}
+
 func (vm *VM) consumeQueuedInput() (string, bool) {
 	if len(vm.input.Queue) == 0 {
 		return "", false
@@ -158,6 +171,9 @@ func (vm *VM) resolveInput(req InputRequest) (string, bool, error) {
 	raw, ok := vm.consumeQueuedInput()
 	if !ok {
 		if vm.inputProvider != nil {
+			// The player has to see the prompt, so an open batch is
+			// flushed before blocking.
+			vm.flushBatch()
 			value, timeout, err := vm.inputProvider(req)
 			if err != nil {
 				vm.finishInputRequest("", false)
@@ -214,70 +230,111 @@ func (vm *VM) execWaitLike(name, arg string) (execResult, error) {
 	if err != nil {
 		return execResult{}, err
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
-func (vm *VM) execInputIntLike(name, arg string) (execResult, error) {
-	req := InputRequest{Command: name, Numeric: true, OneInput: strings.HasPrefix(name, "ONE") || strings.HasPrefix(name, "TONE"), Timed: strings.HasPrefix(name, "T"), Nullable: false}
+// parseInputArgs fills timeout, default and countdown settings for the
+// INPUT family. Untimed forms take the default first; timed forms (TINPUT,
+// TONEINPUT, ...) take "timeout, default, countdown, message".
+func (vm *VM) parseInputArgs(req *InputRequest, arg string) error {
 	if !req.Timed {
-		if strings.TrimSpace(arg) != "" {
-			v, err := vm.evalLooseExpr(arg)
-			if err != nil {
-				return execResult{}, err
-			}
-			def := v.Int64()
-			if req.OneInput {
-				if def >= 0 {
-					req.HasDefault = true
-					req.DefaultValue = Int(normalizeOneDigit(def))
-				}
-			} else {
-				req.HasDefault = true
-				req.DefaultValue = Int(def)
-			}
-		}
-	} else {
-		parts := splitTopLevelRuntime(arg, ',')
-		if len(parts) == 1 {
-			parts = strings.Fields(arg)
-		}
-		if len(parts) > 0 && strings.TrimSpace(parts[0]) != "" {
-			v, err := vm.evalLooseExpr(parts[0])
-			if err == nil {
-				req.TimeoutMs = v.Int64()
-			}
+		return vm.applyInputDefault(req, arg)
+	}
+	parts := splitTopLevelRuntime(arg, ',')
+	if len(parts) == 1 {
+		parts = strings.Fields(arg)
+	}
+	if len(parts) > 0 && strings.TrimSpace(parts[0]) != "" {
+		v, err := vm.evalLooseExpr(parts[0])
+		if err == nil {
+			req.TimeoutMs = v.Int64()
 		}
-		if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
-			v, err := vm.evalLooseExpr(parts[1])
-			if err == nil {
-				def := v.Int64()
-				if req.OneInput {
-					def = normalizeOneDigit(def)
-				}
-				req.HasDefault = true
-				req.DefaultValue = Int(def)
-			}
+	}
+	if len(parts) > 1 {
+		// Timed commands have always tolerated unparsable defaults.
+		_ = vm.applyInputDefault(req, parts[1])
+	}
+	req.Countdown = true
+	if len(parts) > 2 && strings.TrimSpace(parts[2]) != "" {
+		if v, err := vm.evalLooseExpr(parts[2]); err == nil {
+			req.Countdown = v.Int64() != 0
 		}
-		req.Countdown = true
-		if len(parts) > 2 && strings.TrimSpace(parts[2]) != "" {
-			if v, err := vm.evalLooseExpr(parts[2]); err == nil {
-				req.Countdown = v.Int64() != 0
-			}
+	}
+	if len(parts) > 3 && strings.TrimSpace(parts[3]) != "" {
+		if v, err := vm.evalLooseExpr(parts[3]); err == nil {
+			req.TimeoutMessage = v.String()
+		} else {
+			req.TimeoutMessage = decodeCommandCharSeq(strings.TrimSpace(parts[3]))
 		}
-		if len(parts) > 3 && strings.TrimSpace(parts[3]) != "" {
-			if v, err := vm.evalLooseExpr(parts[3]); err == nil {
-				req.TimeoutMessage = v.String()
-			} else {
-				req.TimeoutMessage = decodeCommandCharSeq(strings.TrimSpace(parts[3]))
+	}
+	return nil
+}
+
+// applyInputDefault normalises a default the same way the typed input would
+// be: one-key numeric defaults keep a single digit (negative means none) and
+// one-key string defaults keep their first character.
+func (vm *VM) applyInputDefault(req *InputRequest, raw string) error {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	v, err := vm.evalLooseExpr(raw)
+	if err != nil {
+		return err
+	}
+	if req.Numeric {
+		def := v.Int64()
+		if req.OneInput {
+			if def < 0 {
+				return nil
 			}
+			def = normalizeOneDigit(def)
 		}
+		req.HasDefault = true
+		req.DefaultValue = Int(def)
+		return nil
+	}
+	def := v.String()
+	if req.OneInput {
+		def = firstRune(def)
 	}
+	req.HasDefault = true
+	req.DefaultValue = Str(def)
+	return nil
+}
 
-	raw, _, err := vm.resolveInput(req)
+// maxInputRetries bounds how often a numeric INPUT re-asks the provider
+// before giving up on a non-numeric answer.
+const maxInputRetries = 16
+
+func (vm *VM) execInputIntLike(name, arg string) (execResult, error) {
+	req := InputRequest{Command: name, Numeric: true, OneInput: strings.HasPrefix(name, "ONE") || strings.HasPrefix(name, "TONE"), Timed: strings.HasPrefix(name, "T"), Nullable: false}
+	if err := vm.parseInputArgs(&req, arg); err != nil {
+		return execResult{}, err
+	}
+
+	raw, timeout, err := vm.resolveInput(req)
 	if err != nil {
 		return execResult{}, err
 	}
+	// With an interactive provider, re-prompt until the answer is a number
+	// (or empty/timed out), up to maxInputRetries times. Headless runs and
+	// answers still rejected after that keep going and flag RESULT:1,
+	// which an accepted value clears.
+	for retries := 0; vm.inputProvider != nil && raw != "" && !timeout && retries < maxInputRetries; retries++ {
+		if _, ok := parseIntInput(raw); ok {
+			break
+		}
+		if err := vm.checkRunCancelled(); err != nil {
+			return execResult{}, err
+		}
+		req.Rejected = raw
+		raw, timeout, err = vm.resolveInput(req)
+		if err != nil {
+			return execResult{}, err
+		}
+	}
+	invalid := false
 	var n int64
 	if raw == "" {
 		if req.HasDefault {
@@ -288,6 +345,7 @@ func (vm *VM) execInputIntLike(name, arg string) (execResult, error) {
 	} else {
 		parsed, ok := parseIntInput(raw)
 		if !ok {
+			invalid = true
 			if req.HasDefault {
 				n = req.DefaultValue.Int64()
 			} else {
@@ -300,61 +358,20 @@ func (vm *VM) execInputIntLike(name, arg string) (execResult, error) {
 	if req.OneInput {
 		n = normalizeOneDigit(n)
 	}
-	vm.globals["RESULT"] = Int(n)
+	vm.setResultVar("RESULT", Int(n))
+	flag := Int(0)
+	if invalid {
+		flag = Int(1)
+	}
+	_ = vm.setResultAt("RESULT", []int64{1}, flag)
 	vm.maybeEchoInput(strconv.FormatInt(n, 10))
 	return execResult{kind: resultNone}, nil
 }
 
 func (vm *VM) execInputStringLike(name, arg string) (execResult, error) {
 	req := InputRequest{Command: name, Numeric: false, OneInput: strings.HasPrefix(name, "ONE") || strings.HasPrefix(name, "TONE"), Timed: strings.HasPrefix(name, "T"), Nullable: false}
-	if !req.Timed {
-		if strings.TrimSpace(arg) != "" {
-			v, err := vm.evalLooseExpr(arg)
-			if err != nil {
-				return execResult{}, err
-			}
-			def := v.String()
-			if req.OneInput {
-				def = firstRune(def)
-			}
-			req.HasDefault = true
-			req.DefaultValue = Str(def)
-		}
-	} else {
-		parts := splitTopLevelRuntime(arg, ',')
-		if len(parts) == 1 {
-			parts = strings.Fields(arg)
-		}
-		if len(parts) > 0 && strings.TrimSpace(parts[0]) != "" {
-			v, err := vm.evalLooseExpr(parts[0])
-			if err == nil {
-				req.TimeoutMs = v.Int64()
-			}
-		}
-		if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
-			v, err := vm.evalLooseExpr(parts[1])
-			if err == nil {
-				def := v.String()
-				if req.OneInput {
-					def = firstRune(def)
-				}
-				req.HasDefault = true
-				req.DefaultValue = Str(def)
-			}
-		}
-		req.Countdown = true
-		if len(parts) > 2 && strings.TrimSpace(parts[2]) != "" {
-			if v, err := vm.evalLooseExpr(parts[2]); err == nil {
-				req.Countdown = v.Int64() != 0
-			}
-		}
-		if len(parts) > 3 && strings.TrimSpace(parts[3]) != "" {
-			if v, err := vm.evalLooseExpr(parts[3]); err == nil {
-				req.TimeoutMessage = v.String()
-			} else {
-				req.TimeoutMessage = decodeCommandCharSeq(strings.TrimSpace(parts[3]))
-			}
-		}
+	if err := vm.parseInputArgs(&req, arg); err != nil {
+		return execResult{}, err
 	}
 
 	raw, _, err := vm.resolveInput(req)
@@ -374,7 +391,7 @@ func (vm *VM) execInputStringLike(name, arg string) (execResult, error) {
 	if req.OneInput {
 		out = firstRune(out)
 	}
-	vm.globals["RESULTS"] = Str(out)
+	vm.setResultVar("RESULTS", Str(out))
 	vm.maybeEchoInput(out)
 	return execResult{kind: resultNone}, nil
 }
@@ -1,21 +1,53 @@
 package eruntime
 
 import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type saveValue struct {
-	Kind string `json:"kind"`
-	I    int64  `json:"i,omitempty"`
-	S    string `json:"s,omitempty"`
+	Kind string  `json:"kind"`
+	I    int64   `json:"i,omitempty"`
+	F    float64 `json:"f,omitempty"`
+	S    string  `json:"s,omitempty"`
+}
+
+// MarshalJSON always writes the string payload for string values so an
+// explicitly empty string is recorded as present rather than elided.
+func (v saveValue) MarshalJSON() ([]byte, error) {
+	type plain saveValue
+	if v.Kind != "string" {
+		return json.Marshal(plain(v))
+	}
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		S    string `json:"s"`
+	}{Kind: v.Kind, S: v.S})
 }
 
 type saveSnapshot struct {
-	Globals map[string]saveValue         `json:"globals"`
-	GArrays map[string]saveArraySnapshot `json:"g_arrays,omitempty"`
+	UniqueCode int64                        `json:"unique_code"`
+	Version    int64                        `json:"version"`
+	Globals    map[string]saveValue         `json:"globals"`
+	GArrays    map[string]saveArraySnapshot `json:"g_arrays,omitempty"`
+}
+
+// saveHeader is the identity prefix of a slot file; nil fields mean the
+// slot predates identity stamping.
+type saveHeader struct {
+	UniqueCode *int64 `json:"unique_code"`
+	Version    *int64 `json:"version"`
 }
 
 type saveArraySnapshot struct {
@@ -48,34 +80,26 @@ func (vm *VM) saveGlobals(slot string) error {
 		return err
 	}
 	snap := saveSnapshot{
-		Globals: map[string]saveValue{},
-		GArrays: map[string]saveArraySnapshot{},
+		UniqueCode: vm.saveUniqueCode,
+		Version:    vm.saveVersion,
+		Globals:    map[string]saveValue{},
+		GArrays:    map[string]saveArraySnapshot{},
 	}
 	for k, v := range vm.globals {
-		sv := saveValue{}
-		if v.Kind() == StringKind {
-			sv.Kind = "string"
-			sv.S = v.String()
-		} else {
-			sv.Kind = "int"
-			sv.I = v.Int64()
+		if vm.noData[k] {
+			continue
 		}
-		snap.Globals[k] = sv
+		snap.Globals[k] = valueToSaveValue(v)
 	}
-	for name, arr := range vm.gArrays {
+	for name, arr := range vm.saveArrays() {
+		if vm.noData[name] {
+			continue
+		}
 		cpDims := make([]int, len(arr.Dims))
 		copy(cpDims, arr.Dims)
 		data := map[string]saveValue{}
 		for key, v := range arr.Data {
-			sv := saveValue{}
-			if v.Kind() == StringKind {
-				sv.Kind = "string"
-				sv.S = v.String()
-			} else {
-				sv.Kind = "int"
-				sv.I = v.Int64()
-			}
-			data[key] = sv
+			data[key] = valueToSaveValue(v)
 		}
 		snap.GArrays[name] = saveArraySnapshot{
 			IsString:  arr.IsString,
@@ -111,22 +135,14 @@ func (vm *VM) loadGlobals(slot string) (bool, error) {
 		return false, fmt.Errorf("parse save: %w", err)
 	}
 	for k, sv := range snap.Globals {
-		if sv.Kind == "string" {
-			vm.globals[k] = Str(sv.S)
-		} else {
-			vm.globals[k] = Int(sv.I)
-		}
+		vm.globals[k] = saveValueToValue(sv)
 	}
 	for name, saved := range snap.GArrays {
 		arr := newArrayVar(saved.IsString, saved.IsDynamic, saved.Dims)
 		for key, sv := range saved.Data {
-			if sv.Kind == "string" {
-				arr.Data[key] = Str(sv.S)
-			} else {
-				arr.Data[key] = Int(sv.I)
-			}
+			arr.Data[key] = saveValueToValue(sv)
 		}
-		vm.gArrays[name] = arr
+		vm.storeSavedArray(name, arr)
 	}
 	return true, nil
 }
@@ -156,3 +172,321 @@ func (vm *VM) hasSave(slot string) (bool, error) {
 	}
 	return false, err
 }
+
+// checkSaveCompat reports whether a slot exists and, if so, whether its
+// stamped unique code and version match the running game. Slots written
+// before identity stamping are treated as compatible.
+func (vm *VM) checkSaveCompat(slot string) (exists bool, compatible bool, err error) {
+	path, err := vm.savePath(slot)
+	if err != nil {
+		return false, false, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	var h saveHeader
+	if err := json.Unmarshal(b, &h); err != nil {
+		return true, false, nil
+	}
+	if h.UniqueCode != nil && !vm.saveUniqueCompatible(*h.UniqueCode) {
+		return true, false, nil
+	}
+	if h.Version != nil && *h.Version != vm.saveVersion {
+		return true, false, nil
+	}
+	return true, true, nil
+}
+
+// SaveInfo describes a save without loading it: the SAVEDATA_TEXT of a
+// SAVEDATA/SAVEGAME slot, or the message of a SAVEVAR file named slot in
+// either the JSON or the binary format. savedAt is the recorded save time
+// when the file has one and its modification time otherwise. Only the
+// header fields are decoded, and the save directory is never created.
+func (vm *VM) SaveInfo(slot string) (exists bool, message string, savedAt time.Time, err error) {
+	dir := vm.saveDir
+	if dir == "" {
+		dir = "."
+	}
+	gameSlot := slot
+	if gameSlot == "" {
+		gameSlot = "default"
+	}
+	path := filepath.Join(dir, gameSlot+".json")
+	st, err := os.Stat(path)
+	switch {
+	case err == nil:
+		mes, err := readGameSaveText(path)
+		if err != nil {
+			return true, "", st.ModTime(), fmt.Errorf("parse save: %w", err)
+		}
+		return true, mes, st.ModTime(), nil
+	case !os.IsNotExist(err):
+		return false, "", time.Time{}, err
+	}
+	for _, name := range []string{"var_" + slot + ".dat", "var_" + slot + ".json"} {
+		path := filepath.Join(dir, name)
+		st, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return false, "", time.Time{}, err
+		}
+		ok, mes, at, err := readVarSaveHeader(path)
+		if err != nil {
+			return false, "", time.Time{}, err
+		}
+		if !ok {
+			return true, "", st.ModTime(), fmt.Errorf("parse save: %s is not a var save", name)
+		}
+		t, perr := time.Parse(time.RFC3339Nano, at)
+		if perr != nil {
+			t = st.ModTime()
+		}
+		return true, mes, t, nil
+	}
+	return false, "", time.Time{}, nil
+}
+
+// readGameSaveText returns the SAVEDATA_TEXT global of a slot file,
+// decoding no other value.
+func readGameSaveText(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var text string
+	err = scanJSONObject(f, func(key string, dec *json.Decoder) (bool, error) {
+		if key != "globals" {
+			return false, nil
+		}
+		found := false
+		err := scanJSONValue(dec, func(name string, dec *json.Decoder) (bool, error) {
+			if name != "SAVEDATA_TEXT" {
+				return false, nil
+			}
+			var sv saveValue
+			if err := dec.Decode(&sv); err != nil {
+				return true, err
+			}
+			text, found = sv.S, true
+			return true, errStopScan
+		})
+		if err == nil && found {
+			err = errStopScan
+		}
+		return true, err
+	})
+	return text, err
+}
+
+// ListSaves returns the slot names found in the save directory, in the form
+// SaveInfo and LOADVAR accept: SAVEDATA/SAVEGAME/SAVEGLOBAL slots and SAVEVAR
+// files by their name, with the "var_" file prefix stripped, each listed
+// once. Numeric slots come first in numeric order, then the rest by name.
+// Only files whose header marks them as erago saves are listed, so unrelated
+// files in the directory and character saves are skipped. An unset save
+// directory yields an empty list.
+func (vm *VM) ListSaves() ([]string, error) {
+	names := []string{}
+	if vm.saveDir == "" {
+		return names, nil
+	}
+	entries, err := os.ReadDir(vm.saveDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return names, nil
+		}
+		return nil, err
+	}
+	seen, listed := map[string]bool{}, map[string]bool{}
+	for _, e := range entries {
+		name := e.Name()
+		ext := filepath.Ext(name)
+		if e.IsDir() || (ext != ".json" && ext != ".dat") {
+			continue
+		}
+		name = strings.TrimSuffix(name, ext)
+		if name == "" || seen[name] {
+			continue
+		}
+		path := filepath.Join(vm.saveDir, e.Name())
+		isVar := strings.HasPrefix(name, "var_") && name != "var_"
+		var ok bool
+		switch {
+		case isVar:
+			ok, _, _, err = readVarSaveHeader(path)
+		case ext == ".json":
+			ok, err = isGameSaveFile(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		seen[name] = true
+		if isVar {
+			name = strings.TrimPrefix(name, "var_")
+		}
+		if !listed[name] {
+			listed[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return slotNameLess(names[i], names[j]) })
+	return names, nil
+}
+
+// slotNameLess orders numeric slot names by value ahead of every other
+// name, which sort by text.
+func slotNameLess(a, b string) bool {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		if na != nb {
+			return na < nb
+		}
+		return a < b
+	case errA == nil:
+		return true
+	case errB == nil:
+		return false
+	}
+	return a < b
+}
+
+// errStopScan ends scanJSONObject early once a visitor has what it needs.
+var errStopScan = errors.New("stop scan")
+
+// scanJSONObject streams the top-level object in r, calling visit for each
+// key with dec positioned at its value. visit either decodes the value and
+// reports true, or reports false to have it skipped; returning errStopScan
+// ends the scan without error.
+func scanJSONObject(r io.Reader, visit func(key string, dec *json.Decoder) (bool, error)) error {
+	return scanJSONValue(json.NewDecoder(r), visit)
+}
+
+// scanJSONValue is scanJSONObject for the object at dec's position, such
+// as a nested one; a complete scan also consumes the closing brace.
+func scanJSONValue(dec *json.Decoder, visit func(key string, dec *json.Decoder) (bool, error)) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("not a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		handled, err := visit(key, dec)
+		if errors.Is(err, errStopScan) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !handled {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// isGameSaveFile reports whether path is a SAVEDATA-style slot: a JSON
+// object carrying unique_code or, for slots older than identity stamping,
+// globals.
+func isGameSaveFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	found := false
+	_ = scanJSONObject(f, func(key string, _ *json.Decoder) (bool, error) {
+		if key == "unique_code" || key == "globals" {
+			found = true
+			return false, errStopScan
+		}
+		return false, nil
+	})
+	return found, nil
+}
+
+// varHeaderReadLimit bounds how much of a binary var save readVarSaveHeader
+// reads; the header fields are far smaller.
+const varHeaderReadLimit = 64 << 10
+
+// readVarSaveHeader reads the message and save time of a SAVEVAR file in
+// either format without decoding its variables. ok is false for a missing
+// file or one that is not a var save; savedAt is empty for binary files.
+func readVarSaveHeader(path string) (ok bool, saveMes, savedAt string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, "", "", nil
+		}
+		return false, "", "", err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(8); isGzipData(magic) || (len(magic) == 8 && binary.LittleEndian.Uint64(magic) == eraBDHeader) {
+		var src io.Reader = r
+		if isGzipData(magic) {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return false, "", "", nil
+			}
+			defer zr.Close()
+			src = zr
+		}
+		// The header sits at the front of the file; the variables after it
+		// are never read.
+		b, err := io.ReadAll(io.LimitReader(src, varHeaderReadLimit))
+		if err != nil {
+			return false, "", "", nil
+		}
+		br, err := newEraBinaryReader(b)
+		if err != nil {
+			return false, "", "", nil
+		}
+		if _, _, mes, err := readVarBinaryHeader(br); err == nil {
+			return true, mes, "", nil
+		}
+		return false, "", "", nil
+	}
+	var format string
+	scanErr := scanJSONObject(r, func(key string, dec *json.Decoder) (bool, error) {
+		switch key {
+		case "format":
+			return true, dec.Decode(&format)
+		case "saved_at":
+			return true, dec.Decode(&savedAt)
+		case "save_mes":
+			return true, dec.Decode(&saveMes)
+		}
+		// The header fields are written first; anything else is the body.
+		return false, errStopScan
+	})
+	if scanErr != nil || format != varSnapshotFormat {
+		return false, "", "", nil
+	}
+	return true, saveMes, savedAt, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 func ConvertDatFile(kind, inputPath, outputPath, outputFormat string) error {
@@ -93,7 +94,7 @@ func ConvertDatFile(kind, inputPath, outputPath, outputFormat string) error {
 			}
 		}
 		if outputFormat == "json" {
-			snap := buildCharaSnapshot(saveMes, indices, chars)
+			snap := buildCharaSnapshot(saveMes, indices, chars, time.Now())
 			return writeCharaSnapshotJSON(outputPath, snap)
 		}
 		vm := &VM{saveUniqueCode: unique, saveVersion: version}
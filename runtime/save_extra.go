@@ -3,15 +3,21 @@ package eruntime
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gosuda/erago/ast"
 )
 
+const varSnapshotFormat = "erago.var.v1"
+
 type varDataSnapshot struct {
 	Format    string                       `json:"format"`
 	SavedAt   string                       `json:"saved_at"`
@@ -98,15 +104,21 @@ func (vm *VM) charaDatJSONPath(name string) (string, error) {
 }
 
 func valueToSaveValue(v Value) saveValue {
-	if v.Kind() == StringKind {
+	switch v.Kind() {
+	case StringKind:
 		return saveValue{Kind: "string", S: v.String()}
+	case FloatKind:
+		return saveValue{Kind: "float", F: v.Float64()}
 	}
 	return saveValue{Kind: "int", I: v.Int64()}
 }
 
 func saveValueToValue(v saveValue) Value {
-	if strings.EqualFold(v.Kind, "string") {
+	switch {
+	case strings.EqualFold(v.Kind, "string"):
 		return Str(v.S)
+	case strings.EqualFold(v.Kind, "float"):
+		return Float(v.F)
 	}
 	return Int(v.I)
 }
@@ -122,6 +134,115 @@ func cloneArrayVar(arr *ArrayVar) *ArrayVar {
 	return cp
 }
 
+// isCharacterArrayBase reports whether name's elements indexed by a roster
+// slot are stored on the characters rather than in the global array.
+func (vm *VM) isCharacterArrayBase(name string) bool {
+	return slices.Contains(characterArrayBases, name) && !vm.isCharacterTextBase(name)
+}
+
+// saveArrayView returns a global array the way save paths address it: the
+// plain elements plus the values stored on each character, keyed
+// "slot:index" as in CFLAG:slot:index. arr itself is returned when no
+// character holds a value of name; arr may be nil.
+func (vm *VM) saveArrayView(name string, arr *ArrayVar) *ArrayVar {
+	if !vm.isCharacterArrayBase(name) {
+		return arr
+	}
+	prefix := name + ":"
+	var view *ArrayVar
+	for slot, ch := range vm.characters {
+		for key, v := range ch.Vars {
+			rest, ok := strings.CutPrefix(key, prefix)
+			if !ok {
+				continue
+			}
+			if view == nil {
+				view = cloneArrayVar(arr)
+				if view == nil {
+					view = newArrayVar(vm.isStringArrayBase(name), true, nil)
+				}
+			}
+			key := strconv.Itoa(slot) + ":" + rest
+			view.Data[key] = v
+			if idx, ok := parseIndexKey(key); ok && view.IsDynamic {
+				for i, n := range idx {
+					if i >= len(view.Dims) {
+						view.Dims = append(view.Dims, 0)
+					}
+					view.Dims[i] = max(view.Dims[i], int(n)+1)
+				}
+			}
+		}
+	}
+	if view == nil {
+		return arr
+	}
+	return view
+}
+
+// saveArrays returns every global array that save paths write, with the
+// character values folded in by saveArrayView.
+func (vm *VM) saveArrays() map[string]*ArrayVar {
+	arrays := make(map[string]*ArrayVar, len(vm.gArrays))
+	for name, arr := range vm.gArrays {
+		arrays[name] = vm.saveArrayView(name, arr)
+	}
+	for _, name := range characterArrayBases {
+		if _, ok := arrays[name]; ok {
+			continue
+		}
+		if view := vm.saveArrayView(name, nil); view != nil {
+			arrays[name] = view
+		}
+	}
+	return arrays
+}
+
+// lookupSaveArray is lookupArray with the character values folded in.
+func (vm *VM) lookupSaveArray(name string) (*ArrayVar, bool) {
+	arr, ok := vm.lookupArray(name)
+	if view := vm.saveArrayView(strings.ToUpper(name), arr); view != arr {
+		return view, true
+	}
+	return arr, ok
+}
+
+// storeSavedArray installs a loaded global array. Elements of a character
+// array whose first index is a current roster slot move back onto that
+// character, whose previous values of the array are dropped just as the
+// rest of the array is replaced.
+func (vm *VM) storeSavedArray(name string, arr *ArrayVar) {
+	if vm.isCharacterArrayBase(name) {
+		prefix := name + ":"
+		for i := range vm.characters {
+			for key := range vm.characters[i].Vars {
+				if strings.HasPrefix(key, prefix) {
+					delete(vm.characters[i].Vars, key)
+				}
+			}
+		}
+		for key, v := range arr.Data {
+			idx, ok := parseIndexKey(key)
+			if !ok {
+				continue
+			}
+			slot, charKey, ok := vm.characterVarSlot(name, idx)
+			if !ok {
+				continue
+			}
+			ch := &vm.characters[slot]
+			if ch.Vars == nil {
+				ch.Vars = map[string]Value{}
+			}
+			ch.Vars[charKey] = v
+			delete(arr.Data, key)
+		}
+	}
+	vm.gArrays[name] = arr
+}
+
+// sortedStringKeys is how snapshot, dump and hash code walks a map, so their
+// output does not depend on map iteration order.
 func sortedStringKeys[V any](m map[string]V) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
@@ -131,15 +252,87 @@ func sortedStringKeys[V any](m map[string]V) []string {
 	return keys
 }
 
+// StateHash returns a 64-bit FNV-1a digest, in hex, of the globals, global
+// arrays and characters. RESULT and RESULTS are left out, and every value
+// equal to what reading it would return if it were unset counts as absent,
+// so equal states hash equally however they were reached.
+func (vm *VM) StateHash() string {
+	h := fnv.New64a()
+	writeValue := func(key string, v Value) {
+		fmt.Fprintf(h, "%s=%d:%s\n", key, v.Kind(), v.String())
+	}
+	for _, name := range sortedStringKeys(vm.globals) {
+		v := vm.globals[name]
+		if isResultLikeName(name) || sameValue(v, Int(0)) || sameValue(v, Str("")) {
+			continue
+		}
+		writeValue(name, v)
+	}
+	for _, name := range sortedStringKeys(vm.gArrays) {
+		if isResultLikeName(name) {
+			continue
+		}
+		arr := vm.gArrays[name]
+		def := arr.defaultValue()
+		header := false
+		for _, key := range sortedStringKeys(arr.Data) {
+			v := arr.Data[key]
+			if sameValue(v, def) {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(h, "[%s]\n", name)
+				header = true
+			}
+			writeValue(key, v)
+		}
+	}
+	for _, ch := range vm.characters {
+		fmt.Fprintf(h, "<%d>\n", ch.ID)
+		for _, key := range sortedStringKeys(ch.Vars) {
+			v := ch.Vars[key]
+			if sameValue(v, vm.characterVarUnset(ch, key, v)) {
+				continue
+			}
+			writeValue(key, v)
+		}
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// sameValue reports whether a and b have the same kind and text.
+func sameValue(a, b Value) bool {
+	return a.Kind() == b.Kind() && a.String() == b.String()
+}
+
+// characterVarUnset is what the Vars entry key of ch would read without
+// it: the CSV fallback for a character array element, and otherwise the
+// zero value of v's kind.
+func (vm *VM) characterVarUnset(ch RuntimeCharacter, key string, v Value) Value {
+	if base, rest, ok := strings.Cut(key, ":"); ok && vm.isCharacterArrayBase(base) {
+		if sub, ok := parseIndexKey(rest); ok {
+			return vm.characterVarFallback(base, ch, sub)
+		}
+	}
+	if v.Kind() == StringKind {
+		return Str("")
+	}
+	return Int(0)
+}
+
 func (vm *VM) collectVarSelection(selectors []string) (map[string]Value, map[string]*ArrayVar) {
 	globals := map[string]Value{}
 	arrays := map[string]*ArrayVar{}
 	if len(selectors) == 0 {
 		for k, v := range vm.globals {
-			globals[k] = v
+			if !vm.noData[k] {
+				globals[k] = v
+			}
 		}
-		for k, arr := range vm.gArrays {
-			arrays[k] = cloneArrayVar(arr)
+		for k, arr := range vm.saveArrays() {
+			if !vm.noData[k] {
+				arrays[k] = cloneArrayVar(arr)
+			}
 		}
 		return globals, arrays
 	}
@@ -149,6 +342,9 @@ func (vm *VM) collectVarSelection(selectors []string) (map[string]Value, map[str
 		if raw == "" {
 			continue
 		}
+		if vm.collectVarRange(raw, arrays) {
+			continue
+		}
 		ref, err := vm.parseVarRefRuntime(raw)
 		if err != nil {
 			if v, ev := vm.evalLooseExpr(raw); ev == nil {
@@ -156,7 +352,7 @@ func (vm *VM) collectVarSelection(selectors []string) (map[string]Value, map[str
 				if name == "" {
 					continue
 				}
-				if arr, ok := vm.lookupArray(name); ok {
+				if arr, ok := vm.lookupSaveArray(name); ok {
 					arrays[name] = cloneArrayVar(arr)
 				} else {
 					globals[name] = vm.getVar(name)
@@ -170,7 +366,7 @@ func (vm *VM) collectVarSelection(selectors []string) (map[string]Value, map[str
 			continue
 		}
 		if len(ref.Index) == 0 {
-			if arr, ok := vm.lookupArray(base); ok {
+			if arr, ok := vm.lookupSaveArray(base); ok {
 				arrays[base] = cloneArrayVar(arr)
 			} else {
 				globals[base] = vm.getVar(base)
@@ -186,7 +382,7 @@ func (vm *VM) collectVarSelection(selectors []string) (map[string]Value, map[str
 		if err != nil {
 			continue
 		}
-		src, ok := vm.lookupArray(base)
+		src, ok := vm.lookupSaveArray(base)
 		if !ok {
 			continue
 		}
@@ -202,10 +398,99 @@ func (vm *VM) collectVarSelection(selectors []string) (map[string]Value, map[str
 	return globals, arrays
 }
 
+// saveVarRangePattern matches a selector such as ARR:3-7 or CFLAG:0:2-5,
+// where the literal bounds of the last index select an inclusive range.
+var saveVarRangePattern = regexp.MustCompile(`^(.+):\s*(\d+)\s*-\s*(\d+)$`)
+
+// collectVarRange copies a ranged selector's elements into arrays and
+// reports whether raw was a range selector at all. Elements of a character
+// array are read from the character, and the range stops at the end of the
+// array so an open-ended range on a dynamic array stays small.
+func (vm *VM) collectVarRange(raw string, arrays map[string]*ArrayVar) bool {
+	m := saveVarRangePattern.FindStringSubmatch(raw)
+	if m == nil {
+		return false
+	}
+	lo, errLo := strconv.ParseInt(m[2], 10, 64)
+	hi, errHi := strconv.ParseInt(m[3], 10, 64)
+	if errLo != nil || errHi != nil {
+		return false
+	}
+	ref, err := vm.parseVarRefRuntime(m[1])
+	if err != nil {
+		return false
+	}
+	base := strings.ToUpper(strings.TrimSpace(ref.Name))
+	src, ok := vm.lookupArray(base)
+	if !ok && !vm.isCharacterArrayBase(base) {
+		return true
+	}
+	prefix, err := vm.evalIndexExprs(ref.Index)
+	if err != nil {
+		return true
+	}
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	hi = min(hi, vm.varRangeLimit(base, src, prefix)-1)
+	arr, exists := arrays[base]
+	if !exists {
+		if src != nil {
+			arr = newArrayVar(src.IsString, src.IsDynamic, append([]int(nil), src.Dims...))
+		} else {
+			arr = newArrayVar(vm.isStringArrayBase(base), true, nil)
+		}
+		arrays[base] = arr
+	}
+	for i := lo; i <= hi; i++ {
+		idx := append(append([]int64(nil), prefix...), i)
+		if slot, key, ok := vm.characterVarSlot(base, idx); ok {
+			if v, stored := vm.characters[slot].Vars[key]; stored {
+				_ = arr.Set(idx, v)
+			}
+			continue
+		}
+		if src == nil {
+			continue
+		}
+		v, err := src.Get(idx)
+		if err != nil {
+			break
+		}
+		_ = arr.Set(idx, v)
+	}
+	return true
+}
+
+// varRangeLimit is one past the last index a range under prefix can reach:
+// the array's size at that depth, widened to the highest value stored on
+// the character when prefix starts with a roster slot.
+func (vm *VM) varRangeLimit(base string, src *ArrayVar, prefix []int64) int64 {
+	var limit int64
+	if src != nil && len(prefix) < len(src.Dims) {
+		limit = int64(src.Dims[len(prefix)])
+	}
+	slot, key, ok := vm.characterVarSlot(base, append(append([]int64(nil), prefix...), 0))
+	if !ok {
+		return limit
+	}
+	keyPrefix := key[:strings.LastIndex(key, ":")+1]
+	for k := range vm.characters[slot].Vars {
+		rest, ok := strings.CutPrefix(k, keyPrefix)
+		if !ok {
+			continue
+		}
+		if idx, ok := parseIndexKey(rest); ok {
+			limit = max(limit, idx[0]+1)
+		}
+	}
+	return limit
+}
+
 func (vm *VM) buildVarSnapshot(saveMes string, globals map[string]Value, arrays map[string]*ArrayVar) varDataSnapshot {
 	snap := varDataSnapshot{
-		Format:    "erago.var.v1",
-		SavedAt:   time.Now().Format(time.RFC3339Nano),
+		Format:    varSnapshotFormat,
+		SavedAt:   vm.now().Format(time.RFC3339Nano),
 		SaveMes:   saveMes,
 		Globals:   map[string]saveValue{},
 		Arrays:    map[string]saveArraySnapshot{},
@@ -267,7 +552,7 @@ func applyVarSnapshot(vm *VM, snap varDataSnapshot) {
 		for key, sv := range saved.Data {
 			arr.Data[key] = saveValueToValue(sv)
 		}
-		vm.gArrays[strings.ToUpper(name)] = arr
+		vm.storeSavedArray(strings.ToUpper(name), arr)
 	}
 }
 
@@ -332,7 +617,7 @@ func (vm *VM) execSaveVar(arg string) (execResult, error) {
 		}
 	}
 
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -359,7 +644,7 @@ func (vm *VM) execLoadVar(arg string) (execResult, error) {
 
 	loadFromData := func(data []byte) error {
 		if unique, version, _, globals, arrays, err := vm.readVarBinaryData(data); err == nil {
-			if unique != vm.saveUniqueCode {
+			if !vm.saveUniqueCompatible(unique) {
 				return fmt.Errorf("SAVEVAR incompatible unique code")
 			}
 			if version != vm.saveVersion {
@@ -369,7 +654,7 @@ func (vm *VM) execLoadVar(arg string) (execResult, error) {
 				vm.setVar(strings.ToUpper(k), v)
 			}
 			for name, arr := range arrays {
-				vm.gArrays[strings.ToUpper(name)] = arr
+				vm.storeSavedArray(strings.ToUpper(name), arr)
 			}
 			return nil
 		}
@@ -385,7 +670,7 @@ func (vm *VM) execLoadVar(arg string) (execResult, error) {
 		if err := loadFromData(b); err != nil {
 			return execResult{}, err
 		}
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	} else if !os.IsNotExist(err) {
 		return execResult{}, err
@@ -397,13 +682,13 @@ func (vm *VM) execLoadVar(arg string) (execResult, error) {
 			return execResult{}, err
 		}
 		applyVarSnapshot(vm, snap)
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	} else if !os.IsNotExist(err) {
 		return execResult{}, err
 	}
 
-	vm.globals["RESULT"] = Int(0)
+	vm.setResultVar("RESULT", Int(0))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -455,10 +740,10 @@ func parseSaveCharaArgs(arg string, vm *VM) (name, saveMes string, indices []int
 	return
 }
 
-func buildCharaSnapshot(saveMes string, indices []int64, chars []RuntimeCharacter) charaDataSnapshot {
+func buildCharaSnapshot(saveMes string, indices []int64, chars []RuntimeCharacter, savedAt time.Time) charaDataSnapshot {
 	snap := charaDataSnapshot{
-		Format:  "erago.chara.v1",
-		SavedAt: time.Now().Format(time.RFC3339Nano),
+		Format:  charaSnapshotFormat,
+		SavedAt: savedAt.Format(time.RFC3339Nano),
 		SaveMes: saveMes,
 		Indices: append([]int64(nil), indices...),
 		Chars:   make([]charaSaveItem, 0, len(chars)),
@@ -481,11 +766,42 @@ func writeCharaSnapshotJSON(path string, snap charaDataSnapshot) error {
 	return os.WriteFile(path, b, 0o644)
 }
 
+// charaSnapshotFormat is the Format written by SAVECHARA JSON files.
+const charaSnapshotFormat = "erago.chara.v1"
+
+// charaSnapshotMigrations upgrades an older chara snapshot one step, keyed by
+// the Format it reads. Each step must change Format, ending at
+// charaSnapshotFormat. Files without a Format predate the field and are v1.
+var charaSnapshotMigrations = map[string]func(charaDataSnapshot) (charaDataSnapshot, error){
+	"": func(snap charaDataSnapshot) (charaDataSnapshot, error) {
+		snap.Format = charaSnapshotFormat
+		return snap, nil
+	},
+}
+
 func readCharaSnapshotJSON(data []byte) (charaDataSnapshot, error) {
 	var snap charaDataSnapshot
 	if err := json.Unmarshal(data, &snap); err != nil {
 		return charaDataSnapshot{}, err
 	}
+	return migrateCharaSnapshot(snap)
+}
+
+func migrateCharaSnapshot(snap charaDataSnapshot) (charaDataSnapshot, error) {
+	for snap.Format != charaSnapshotFormat {
+		migrate, ok := charaSnapshotMigrations[snap.Format]
+		if !ok {
+			return charaDataSnapshot{}, fmt.Errorf("unsupported chara snapshot format %q (want %q)", snap.Format, charaSnapshotFormat)
+		}
+		next, err := migrate(snap)
+		if err != nil {
+			return charaDataSnapshot{}, fmt.Errorf("migrate chara snapshot %q: %w", snap.Format, err)
+		}
+		if next.Format == snap.Format {
+			return charaDataSnapshot{}, fmt.Errorf("migrate chara snapshot %q: format unchanged", snap.Format)
+		}
+		snap = next
+	}
 	return snap, nil
 }
 
@@ -535,18 +851,18 @@ func (vm *VM) execSaveChara(arg string) (execResult, error) {
 		if err := vm.writeCharaBinaryFile(datPath, saveMes, selected); err != nil {
 			return execResult{}, err
 		}
-		snap := buildCharaSnapshot(saveMes, indices, selected)
+		snap := buildCharaSnapshot(saveMes, indices, selected, vm.now())
 		if err := writeCharaSnapshotJSON(jsonPath, snap); err != nil {
 			return execResult{}, err
 		}
 	default:
-		snap := buildCharaSnapshot(saveMes, indices, selected)
+		snap := buildCharaSnapshot(saveMes, indices, selected, vm.now())
 		if err := writeCharaSnapshotJSON(datPath, snap); err != nil {
 			return execResult{}, err
 		}
 	}
 
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -573,7 +889,7 @@ func (vm *VM) execLoadChara(arg string) (execResult, error) {
 
 	loadFromData := func(data []byte) error {
 		if unique, version, _, chars, err := vm.readCharaBinaryData(data); err == nil {
-			if unique != vm.saveUniqueCode {
+			if !vm.saveUniqueCompatible(unique) {
 				return fmt.Errorf("SAVECHARA incompatible unique code")
 			}
 			if version != vm.saveVersion {
@@ -602,7 +918,7 @@ func (vm *VM) execLoadChara(arg string) (execResult, error) {
 		if err := loadFromData(b); err != nil {
 			return execResult{}, err
 		}
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	} else if !os.IsNotExist(err) {
 		return execResult{}, err
@@ -622,12 +938,123 @@ func (vm *VM) execLoadChara(arg string) (execResult, error) {
 			chars = append(chars, RuntimeCharacter{ID: item.ID, Vars: vars})
 		}
 		appendLoadedCharacters(vm, chars)
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	} else if !os.IsNotExist(err) {
 		return execResult{}, err
 	}
 
-	vm.globals["RESULT"] = Int(0)
+	vm.setResultVar("RESULT", Int(0))
+	return execResult{kind: resultNone}, nil
+}
+
+// quickSaveSnapshot bundles every piece of resumable state into one file.
+// RNG holds the state of every RANDSTREAM generator and RNGStream the
+// selected one, so the random sequence after QUICKLOAD matches the one that
+// followed QUICKSAVE while saving itself leaves the sequence untouched.
+// RNGSeed is only read, from quicksaves that predate RNG.
+type quickSaveSnapshot struct {
+	Format    string                     `json:"format"`
+	SavedAt   string                     `json:"saved_at"`
+	Vars      varDataSnapshot            `json:"vars"`
+	Chars     charaDataSnapshot          `json:"chars"`
+	UI        UIState                    `json:"ui"`
+	RNG       map[string]randStreamState `json:"rng,omitempty"`
+	RNGStream string                     `json:"rng_stream,omitempty"`
+	RNGSeed   int64                      `json:"rng_seed,omitempty"`
+}
+
+func (vm *VM) quickSavePath(arg string) (string, error) {
+	return vm.savePath("quick_" + vm.evalSaveSlot(arg))
+}
+
+func (vm *VM) execQuickSave(arg string) (execResult, error) {
+	path, err := vm.quickSavePath(arg)
+	if err != nil {
+		return execResult{}, err
+	}
+	indices := make([]int64, len(vm.characters))
+	for i := range indices {
+		indices[i] = int64(i)
+	}
+	snap := quickSaveSnapshot{
+		Format:  "erago.quick.v1",
+		SavedAt: vm.now().Format(time.RFC3339Nano),
+		Vars:    vm.buildVarSnapshot("", vm.globals, vm.gArrays),
+		Chars:   buildCharaSnapshot("", indices, vm.characters, vm.now()),
+		UI:      vm.ui,
+		RNG:     map[string]randStreamState{},
+	}
+	for name, r := range vm.rngStreams {
+		snap.RNG[name] = r.state()
+		if r == vm.rng {
+			snap.RNGStream = name
+		}
+	}
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return execResult{}, fmt.Errorf("marshal quicksave: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		return execResult{}, fmt.Errorf("write quicksave: %w", err)
+	}
+	vm.setResultVar("RESULT", Int(1))
+	return execResult{kind: resultNone}, nil
+}
+
+func (vm *VM) execQuickLoad(arg string) (execResult, error) {
+	path, err := vm.quickSavePath(arg)
+	if err != nil {
+		return execResult{}, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			vm.setResultVar("RESULT", Int(0))
+			return execResult{kind: resultNone}, nil
+		}
+		return execResult{}, err
+	}
+	var snap quickSaveSnapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return execResult{}, fmt.Errorf("parse quicksave: %w", err)
+	}
+	streams := make(map[string]*randStream, len(snap.RNG))
+	for name, st := range snap.RNG {
+		r := newRandStream(0)
+		if err := r.setState(st); err != nil {
+			return execResult{}, fmt.Errorf("parse quicksave: RANDSTREAM %s: %w", name, err)
+		}
+		streams[name] = r
+	}
+	vm.globals = map[string]Value{}
+	for k, sv := range snap.Vars.Globals {
+		vm.globals[strings.ToUpper(k)] = saveValueToValue(sv)
+	}
+	vm.gArrays = map[string]*ArrayVar{}
+	for name, saved := range snap.Vars.Arrays {
+		arr := newArrayVar(saved.IsString, saved.IsDynamic, saved.Dims)
+		for key, sv := range saved.Data {
+			arr.Data[key] = saveValueToValue(sv)
+		}
+		vm.gArrays[strings.ToUpper(name)] = arr
+	}
+	chars := make([]RuntimeCharacter, 0, len(snap.Chars.Chars))
+	for _, item := range snap.Chars.Chars {
+		vars := map[string]Value{}
+		for k, sv := range item.Vars {
+			vars[k] = saveValueToValue(sv)
+		}
+		chars = append(chars, RuntimeCharacter{ID: item.ID, Vars: vars})
+	}
+	vm.loadRoster(chars)
+	vm.ui = snap.UI
+	if len(streams) == 0 {
+		vm.seedRand(snap.RNGSeed)
+	} else {
+		vm.rngStreams = streams
+		vm.selectRandStream(snap.RNGStream)
+	}
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
@@ -2,6 +2,7 @@ package eruntime
 
 import (
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -20,11 +21,17 @@ type UIState struct {
 	PrintCPL     int64
 	PrintCLength int
 	SaveNos      int
+	// TabWidth expands tabs in output to the next multiple of this many
+	// columns; 0 leaves them as literal tab characters.
+	TabWidth int
 }
 
 type RuntimeCharacter struct {
 	ID   int64
 	Vars map[string]Value
+	// void marks an ADDVOIDCHARA character, which never falls back to the
+	// chara CSV row of its ID.
+	void bool
 }
 
 func defaultUIState() UIState {
@@ -51,12 +58,96 @@ func (vm *VM) refreshCharacterGlobals() {
 	vm.globals["CHARANUM"] = Int(int64(len(vm.characters)))
 }
 
-func (vm *VM) addCharacter(id int64) int64 {
+func cloneCharacter(ch RuntimeCharacter) RuntimeCharacter {
+	vars := make(map[string]Value, len(ch.Vars))
+	for k, v := range ch.Vars {
+		vars[k] = v
+	}
+	return RuntimeCharacter{ID: ch.ID, Vars: vars, void: ch.void}
+}
+
+func (vm *VM) Characters() []RuntimeCharacter {
+	out := make([]RuntimeCharacter, len(vm.characters))
+	for i, ch := range vm.characters {
+		out[i] = cloneCharacter(ch)
+	}
+	return out
+}
+
+// SetCharacters replaces the roster. The roster is also kept as the starting
+// roster for later Run calls, which otherwise begin with no characters.
+func (vm *VM) SetCharacters(chars []RuntimeCharacter) {
+	vm.charSeed = make([]RuntimeCharacter, len(chars))
+	for i, ch := range chars {
+		vm.charSeed[i] = cloneCharacter(ch)
+	}
+	vm.loadRoster(chars)
+}
+
+func (vm *VM) loadRoster(chars []RuntimeCharacter) {
+	vm.characters = make([]RuntimeCharacter, len(chars))
+	vm.nextCharID = 0
+	vm.selectedChara = -1
+	for i, ch := range chars {
+		vm.characters[i] = cloneCharacter(ch)
+		if ch.ID >= vm.nextCharID {
+			vm.nextCharID = ch.ID + 1
+		}
+	}
+	vm.refreshCharacterGlobals()
+}
+
+// characterArrayBases lists the arrays whose first index is a character slot.
+var characterArrayBases = []string{
+	"BASE", "MAXBASE", "DOWNBASE", "ABL", "TALENT", "EXP", "MARK", "CFLAG", "CSTR",
+	"RELATION", "JUEL", "GOTJUEL", "EQUIP", "TEQUIP", "PALAM", "SOURCE", "EX", "NOWEX",
+	"STAIN", "CUP", "CDOWN", "TCVAR", "NAME", "CALLNAME", "NICKNAME", "MASTERNAME",
+}
+
+// copyCharacterData makes the character at dst an independent copy of src:
+// its Vars map and every character-indexed array row are duplicated.
+func (vm *VM) copyCharacterData(src, dst int) {
+	if src == dst {
+		return
+	}
+	vm.characters[dst] = cloneCharacter(vm.characters[src])
+	srcKey, dstKey := strconv.Itoa(src), strconv.Itoa(dst)
+	for _, base := range characterArrayBases {
+		arr, ok := vm.gArrays[base]
+		if !ok {
+			continue
+		}
+		copied := map[string]Value{}
+		for k, v := range arr.Data {
+			if k == dstKey || strings.HasPrefix(k, dstKey+":") {
+				delete(arr.Data, k)
+				continue
+			}
+			if k == srcKey || strings.HasPrefix(k, srcKey+":") {
+				copied[dstKey+strings.TrimPrefix(k, srcKey)] = v
+			}
+		}
+		for k, v := range copied {
+			arr.Data[k] = v
+		}
+		if arr.IsDynamic && len(arr.Dims) > 0 && dst >= arr.Dims[0] {
+			arr.Dims[0] = dst + 1
+		}
+	}
+}
+
+// addCharacter appends a character and returns its slot. Unless void is
+// set, its Vars start from the chara CSV row of id.
+func (vm *VM) addCharacter(id int64, void bool) int64 {
 	if id < 0 {
 		id = vm.nextCharID
 		vm.nextCharID++
 	}
-	vm.characters = append(vm.characters, RuntimeCharacter{ID: id, Vars: map[string]Value{}})
+	vars := map[string]Value{}
+	if !void {
+		vars = vm.csv.CharaDefaults(id)
+	}
+	vm.characters = append(vm.characters, RuntimeCharacter{ID: id, Vars: vars, void: void})
 	vm.refreshCharacterGlobals()
 	return int64(len(vm.characters) - 1)
 }
@@ -67,14 +158,36 @@ func (vm *VM) deleteCharacterAt(idx int64) bool {
 	}
 	i := int(idx)
 	vm.characters = append(vm.characters[:i], vm.characters[i+1:]...)
+	switch {
+	case vm.selectedChara == i:
+		vm.selectedChara = -1
+	case vm.selectedChara > i:
+		vm.selectedChara--
+	}
 	vm.refreshCharacterGlobals()
 	return true
 }
 
+// sortCharacters orders the roster by character ID, keeping the SELECTCHARA
+// selection on the character it pointed at.
 func (vm *VM) sortCharacters() {
-	sort.Slice(vm.characters, func(i, j int) bool {
-		return vm.characters[i].ID < vm.characters[j].ID
+	order := make([]int, len(vm.characters))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return vm.characters[order[i]].ID < vm.characters[order[j]].ID
 	})
+	sorted := make([]RuntimeCharacter, len(order))
+	selected := -1
+	for i, from := range order {
+		sorted[i] = vm.characters[from]
+		if from == vm.selectedChara {
+			selected = i
+		}
+	}
+	vm.characters = sorted
+	vm.selectedChara = selected
 }
 
 func normalizeAlign(s string) string {
@@ -1,17 +1,22 @@
 package eruntime
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+)
 
 type ValueKind int
 
 const (
 	IntKind ValueKind = iota
 	StringKind
+	FloatKind
 )
 
 type Value struct {
 	kind ValueKind
 	i    int64
+	f    float64
 	s    string
 }
 
@@ -19,6 +24,12 @@ func Int(v int64) Value {
 	return Value{kind: IntKind, i: v}
 }
 
+// Float makes a floating-point value. Expressions only produce one when a
+// float literal or another float is involved; integer arithmetic is exact.
+func Float(v float64) Value {
+	return Value{kind: FloatKind, f: v}
+}
+
 func Str(v string) Value {
 	return Value{kind: StringKind, s: v}
 }
@@ -28,8 +39,11 @@ func (v Value) Kind() ValueKind {
 }
 
 func (v Value) Int64() int64 {
-	if v.kind == IntKind {
+	switch v.kind {
+	case IntKind:
 		return v.i
+	case FloatKind:
+		return int64(v.f)
 	}
 	i, err := strconv.ParseInt(v.s, 10, 64)
 	if err != nil {
@@ -38,16 +52,61 @@ func (v Value) Int64() int64 {
 	return i
 }
 
+// Float64 returns v as a float; integers convert exactly and strings are
+// parsed, yielding 0 when they are not numeric.
+func (v Value) Float64() float64 {
+	switch v.kind {
+	case IntKind:
+		return float64(v.i)
+	case FloatKind:
+		return v.f
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v.s), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
 func (v Value) String() string {
-	if v.kind == StringKind {
+	switch v.kind {
+	case StringKind:
 		return v.s
+	case FloatKind:
+		return strconv.FormatFloat(v.f, 'f', -1, 64)
 	}
 	return strconv.FormatInt(v.i, 10)
 }
 
+// Truthy reports whether v counts as true in IF, SIF and ternaries.
+// Integers are true when non-zero. Strings that parse as integers follow
+// their number, so "" and "0" are false; any other non-empty string, such
+// as "abc", is true.
 func (v Value) Truthy() bool {
 	if v.kind == StringKind {
+		if n, err := strconv.ParseInt(strings.TrimSpace(v.s), 10, 64); err == nil {
+			return n != 0
+		}
 		return v.s != ""
 	}
+	if v.kind == FloatKind {
+		return v.f != 0
+	}
 	return v.i != 0
 }
+
+// numericValue coerces v for a numeric slot: floats stay floats, anything
+// else becomes an integer.
+func numericValue(v Value) Value {
+	if v.kind == FloatKind {
+		return v
+	}
+	return Int(v.Int64())
+}
+
+// FormatValue returns the text PRINTV emits for v. Unlike String, whose
+// output is an implementation detail, this is part of the stable API for
+// embedders rendering values outside of a script.
+func FormatValue(v Value) string {
+	return v.String()
+}
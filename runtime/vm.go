@@ -1,12 +1,18 @@
 package eruntime
 
 import (
+	"cmp"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
 	"html"
+	"io"
 	"math"
 	"math/rand"
 	"regexp"
+	goruntime "runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -21,38 +27,86 @@ type Output struct {
 	Text       string
 	NewLine    bool
 	ClearLines int
+	// Image is a path or resource ID emitted by PRINTIMG for graphical
+	// frontends; text frontends can ignore lines that carry one.
+	Image string
+	// Color, BgColor, Bold, Italic and Align snapshot the UI state the text
+	// was printed under, so frontends can style it without parsing HTML.
+	Color   string
+	BgColor string
+	Bold    bool
+	Italic  bool
+	Align   string
+	// IsButton marks PRINTBUTTON text; clicking it should feed ButtonValue
+	// into the input queue.
+	IsButton    bool
+	ButtonValue string
 }
 
 type VM struct {
-	program        *ast.Program
-	globals        map[string]Value
-	gArrays        map[string]*ArrayVar
-	gRefDecl       map[string]bool
-	gRefs          map[string]ast.VarRef
-	fLocals        map[string]map[string]Value
-	fArrays        map[string]map[string]*ArrayVar
-	fRefDecl       map[string]map[string]bool
-	fRefs          map[string]map[string]ast.VarRef
-	stack          []*frame
-	outputs        []Output
-	rng            *rand.Rand
-	csv            *CSVStore
-	saveDir        string
-	ui             UIState
-	characters     []RuntimeCharacter
-	nextCharID     int64
-	flowMap        map[*ast.Thunk]*thunkFlow
-	execThunk      *ast.Thunk
-	execPC         int
-	input          InputState
-	saveUniqueCode int64
-	saveVersion    int64
-	datSaveFormat  string
-	outputHook     func(Output)
-	inputProvider  func(InputRequest) (string, bool, error)
-	printCCounter  int
-	execSteps      int64
-	execStepLimit  int64
+	program          *ast.Program
+	globals          map[string]Value
+	gArrays          map[string]*ArrayVar
+	gRefDecl         map[string]bool
+	gRefs            map[string]ast.VarRef
+	fLocals          map[string]map[string]Value
+	fArrays          map[string]map[string]*ArrayVar
+	fRefDecl         map[string]map[string]bool
+	fRefs            map[string]map[string]ast.VarRef
+	stack            []*frame
+	outputs          []Output
+	rng              *randStream
+	csv              *CSVStore
+	saveDir          string
+	ui               UIState
+	characters       []RuntimeCharacter
+	charSeed         []RuntimeCharacter
+	nextCharID       int64
+	flowMap          map[*ast.Thunk]*thunkFlow
+	execThunk        *ast.Thunk
+	execPC           int
+	input            InputState
+	saveUniqueCode   int64
+	saveVersion      int64
+	datSaveFormat    string
+	saveCompression  bool
+	saveCompatMode   string
+	outputHook       func(Output)
+	batchDepth       int
+	batchOutputs     []Output
+	streamWriter     io.Writer
+	streamColumn     int
+	inputProvider    func(InputRequest) (string, bool, error)
+	csvSource        func() (map[string]string, error)
+	assignedNames    map[string]bool
+	printCCounter    int
+	selectedChara    int
+	execSteps        int64
+	execStepLimit    int64
+	runCtx           context.Context
+	maxCallDepth     int
+	maxLoopIter      int64
+	htmlEscapeOutput bool
+	maxLogLines      int
+	maxOutputs       int
+	outputPolicy     OutputLimitPolicy
+	outputErr        error
+	trimmedLines     int64
+	sysInfo          map[string]string
+	levelTables      map[string][]int64
+	clock            func() time.Time
+	noData           map[string]bool
+	strictForms      bool
+	collapseSpaces   bool
+	groupSep         string
+	decimalSep       string
+	windowTitle      string
+	titleHook        func(string)
+	namedColors      map[string]int64
+	rngStreams       map[string]*randStream
+	randLogging      bool
+	randLog          []RandEvent
+	markTime         time.Time
 }
 
 type frame struct {
@@ -61,6 +115,7 @@ type frame struct {
 	lArrays  map[string]*ArrayVar
 	lRefDecl map[string]bool
 	refs     map[string]ast.VarRef
+	argc     int // arguments explicitly passed by the caller
 }
 
 type resultKind int
@@ -85,10 +140,40 @@ type execResult struct {
 	values  []Value
 }
 
+// RuntimeError reports a statement that failed during execution. Errors from
+// nested calls are wrapped, so the chain reads outermost frame first.
+type RuntimeError struct {
+	File string
+	Line int
+	Func string
+	PC   int
+	Stmt ast.Statement
+	Err  error
+}
+
+func (e *RuntimeError) Error() string {
+	loc := ""
+	if e.File != "" {
+		loc = fmt.Sprintf("%s:%d: ", e.File, e.Line)
+	}
+	if e.Func == "" {
+		return fmt.Sprintf("%spc %d (%T): %v", loc, e.PC, e.Stmt, e.Err)
+	}
+	return fmt.Sprintf("%s%s pc %d (%T): %v", loc, e.Func, e.PC, e.Stmt, e.Err)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
 var htmlTagPattern = regexp.MustCompile(`(?is)<[^>]*>`)
 
 const defaultExecStepLimit int64 = 5_000_000
 
+// defaultMaxCallDepth keeps runaway CALL recursion well below the point where
+// the Go stack of the host would overflow.
+const defaultMaxCallDepth = 4096
+
 func New(program *ast.Program) (*VM, error) {
 	vm := &VM{
 		program:        program,
@@ -102,12 +187,13 @@ func New(program *ast.Program) (*VM, error) {
 		fRefs:          map[string]map[string]ast.VarRef{},
 		stack:          nil,
 		outputs:        nil,
-		rng:            rand.New(rand.NewSource(time.Now().UnixNano())),
+		rng:            newRandStream(time.Now().UnixNano()),
 		csv:            newCSVStore(program.CSVFiles),
 		saveDir:        "",
 		ui:             defaultUIState(),
 		characters:     nil,
 		nextCharID:     0,
+		selectedChara:  -1,
 		flowMap:        map[*ast.Thunk]*thunkFlow{},
 		execThunk:      nil,
 		execPC:         -1,
@@ -115,10 +201,35 @@ func New(program *ast.Program) (*VM, error) {
 		saveUniqueCode: 0,
 		saveVersion:    1,
 		datSaveFormat:  "json",
+		saveCompatMode: "strict",
 		outputHook:     nil,
 		inputProvider:  nil,
 		execSteps:      0,
 		execStepLimit:  defaultExecStepLimit,
+		maxCallDepth:   defaultMaxCallDepth,
+		sysInfo: map[string]string{
+			"ENGINE":   "erago",
+			"PLATFORM": goruntime.GOOS,
+			"ARCH":     goruntime.GOARCH,
+		},
+		clock:       time.Now,
+		decimalSep:  ".",
+		noData:      map[string]bool{},
+		namedColors: map[string]int64{},
+		levelTables: map[string][]int64{
+			"PALAMLV": {0, 100, 500, 3000, 10000, 30000, 60000, 100000, 150000, 250000},
+			"EXPLV":   {0, 1, 4, 20, 50, 200},
+		},
+	}
+	vm.rngStreams = map[string]*randStream{defaultRandStream: vm.rng}
+	vm.assignedNames = map[string]bool{}
+	for _, fn := range program.Functions {
+		collectAssignedNames(fn.Body, vm.assignedNames)
+	}
+	for _, fns := range program.EventFunctions {
+		for _, fn := range fns {
+			collectAssignedNames(fn.Body, vm.assignedNames)
+		}
 	}
 	vm.initSaveIdentity()
 	if err := vm.initDefines(); err != nil {
@@ -159,6 +270,9 @@ func (vm *VM) initDefines() error {
 			vm.gRefDecl[name] = true
 			continue
 		}
+		if decl.NoData {
+			vm.noData[name] = true
+		}
 		vm.gArrays[name] = newArrayVar(decl.IsString, decl.IsDynamic, decl.Dims)
 	}
 	for _, k := range indexedKeys {
@@ -183,6 +297,16 @@ func (vm *VM) initDefines() error {
 			vm.globals[name] = Str("")
 		}
 	}
+	vm.applyGameMeta(false)
+	vm.resultArray("RESULT")
+	vm.resultArray("RESULTS")
+	return nil
+}
+
+// applyGameMeta copies GAMEBASE.CSV's fields into the GAMEBASE_* globals.
+// Empty fields leave the current value alone. GAMEBASE_VERSION is only filled
+// in when unset unless overrideVersion is true.
+func (vm *VM) applyGameMeta(overrideVersion bool) {
 	title, author, year, windowTitle, info := vm.csv.GameMeta()
 	if strings.TrimSpace(title) != "" {
 		vm.globals["GAMEBASE_TITLE"] = Str(title)
@@ -195,19 +319,16 @@ func (vm *VM) initDefines() error {
 	}
 	if strings.TrimSpace(windowTitle) != "" {
 		vm.globals["GAMEBASE_WINDOWTITLE"] = Str(windowTitle)
+		vm.windowTitle = windowTitle
 	}
 	if strings.TrimSpace(info) != "" {
 		vm.globals["GAMEBASE_INFO"] = Str(info)
 	}
-	if _, ok := vm.globals["GAMEBASE_VERSION"]; !ok {
+	if _, ok := vm.globals["GAMEBASE_VERSION"]; !ok || overrideVersion {
 		if _, version, _, hasVersion := vm.csv.GameCodeVersion(); hasVersion {
 			vm.globals["GAMEBASE_VERSION"] = Int(version)
 		}
 	}
-	if _, ok := vm.globals["RESULT"]; !ok {
-		vm.globals["RESULT"] = Int(0)
-	}
-	return nil
 }
 
 func (vm *VM) initSaveIdentity() {
@@ -247,11 +368,33 @@ func (vm *VM) initSaveIdentity() {
 }
 
 func (vm *VM) Run(entry string) ([]Output, error) {
+	return vm.RunContext(context.Background(), entry)
+}
+
+// RunContext is Run with cancellation: once ctx is done the script stops
+// before its next statement and the returned error wraps ctx.Err().
+func (vm *VM) RunContext(ctx context.Context, entry string) ([]Output, error) {
+	prevCtx := vm.runCtx
+	vm.runCtx = ctx
+	defer func() { vm.runCtx = prevCtx }()
 	queuedInput := append([]string(nil), vm.input.Queue...)
 	vm.outputs = vm.outputs[:0]
+	vm.trimmedLines = 0
+	vm.streamColumn = 0
+	vm.printCCounter = 0
+	vm.selectedChara = -1
+	vm.randLog = vm.randLog[:0]
+	// A script that exits inside BEGINBATCH must not keep holding output
+	// back; batches opened by the host around Run are left to the host.
+	baseBatchDepth := vm.batchDepth
+	defer func() {
+		vm.batchDepth = baseBatchDepth
+		if baseBatchDepth == 0 {
+			vm.flushBatch()
+		}
+	}()
 	vm.ui = defaultUIState()
-	vm.characters = nil
-	vm.nextCharID = 0
+	vm.loadRoster(vm.charSeed)
 	vm.execSteps = 0
 	vm.input = defaultInputState()
 	vm.input.Queue = queuedInput
@@ -280,10 +423,12 @@ func (vm *VM) Run(entry string) ([]Output, error) {
 			current = vm.resolveBeginTarget(res.keyword)
 			continue
 		case resultQuit:
+			vm.endColumnRow()
 			return append([]Output(nil), vm.outputs...), nil
 		case resultGoto:
 			return nil, fmt.Errorf("uncaught goto %s", res.label)
 		default:
+			vm.endColumnRow()
 			return append([]Output(nil), vm.outputs...), nil
 		}
 	}
@@ -316,7 +461,7 @@ func (vm *VM) runShopFlow() (execResult, error) {
 		if parsed, ok := parseIntInput(raw); ok {
 			n = parsed
 		}
-		vm.globals["RESULT"] = Int(n)
+		vm.setResultVar("RESULT", Int(n))
 		vm.maybeEchoInput(strconv.FormatInt(n, 10))
 
 		userRes, err := vm.callFunction("USERSHOP", nil)
@@ -375,7 +520,7 @@ func (vm *VM) runTrainFlow() (execResult, error) {
 		if parsed, ok := parseIntInput(raw); ok {
 			n = parsed
 		}
-		vm.globals["RESULT"] = Int(n)
+		vm.setResultVar("RESULT", Int(n))
 		vm.maybeEchoInput(strconv.FormatInt(n, 10))
 
 		if n >= 0 && int(n) < len(slots) {
@@ -400,7 +545,7 @@ func (vm *VM) runTrainFlow() (execResult, error) {
 					return comRes, nil
 				}
 			} else {
-				vm.globals["RESULT"] = Int(0)
+				vm.setResultVar("RESULT", Int(0))
 			}
 
 			if vm.getVar("RESULT").Int64() != 0 && vm.program.Functions["SOURCE_CHECK"] != nil {
@@ -542,8 +687,9 @@ func (vm *VM) callEventFunction(name string, args []Value) (execResult, error) {
 			if err != nil {
 				return execResult{}, err
 			}
-			// If any function returns BEGIN/QUIT, propagate it
-			if res.kind == resultBegin || res.kind == resultQuit {
+			// RETURN only ends the current handler; BEGIN, QUIT, RESTART and
+			// other control flow stop the remaining handlers.
+			if res.kind != resultNone {
 				return res, nil
 			}
 		}
@@ -569,6 +715,7 @@ func (vm *VM) callFunctionDef(fn *ast.Function, args []Value, missing []bool, in
 		lArrays:  map[string]*ArrayVar{},
 		lRefDecl: map[string]bool{},
 		refs:     map[string]ast.VarRef{},
+		argc:     passedArgCount(args, missing),
 	}
 	persistLocalArrays := map[string]struct{}{}
 
@@ -586,6 +733,9 @@ func (vm *VM) callFunctionDef(fn *ast.Function, args []Value, missing []bool, in
 		target := vm.normalizeFuncArgTarget(arg, i)
 
 		assignArg := func(v Value) error {
+			if isResultLikeName(target.Name) {
+				return vm.setVarRef(target, v)
+			}
 			if len(target.Index) == 0 {
 				fr.locals[target.Name] = v
 				if target.Name == "ARG" || target.Name == "ARGS" {
@@ -642,7 +792,7 @@ func (vm *VM) callFunctionDef(fn *ast.Function, args []Value, missing []bool, in
 
 	for _, decl := range fn.VarDecls {
 		name := strings.ToUpper(strings.TrimSpace(decl.Name))
-		if name == "" {
+		if name == "" || isResultLikeName(name) {
 			continue
 		}
 		switch decl.Scope {
@@ -652,7 +802,11 @@ func (vm *VM) callFunctionDef(fn *ast.Function, args []Value, missing []bool, in
 				continue
 			}
 			if vm.gArrays[name] == nil {
-				vm.gArrays[name] = newArrayVar(decl.IsString, decl.IsDynamic, decl.Dims)
+				arr, err := vm.newDeclaredArray(decl)
+				if err != nil {
+					return execResult{}, fmt.Errorf("%s #DIM %s: %w", fn.Name, name, err)
+				}
+				vm.gArrays[name] = arr
 			}
 		default:
 			if decl.IsRef {
@@ -665,7 +819,11 @@ func (vm *VM) callFunctionDef(fn *ast.Function, args []Value, missing []bool, in
 				continue
 			}
 			if fr.lArrays[name] == nil {
-				fr.lArrays[name] = newArrayVar(decl.IsString, decl.IsDynamic, decl.Dims)
+				arr, err := vm.newDeclaredArray(decl)
+				if err != nil {
+					return execResult{}, fmt.Errorf("%s #DIM %s: %w", fn.Name, name, err)
+				}
+				fr.lArrays[name] = arr
 			}
 		}
 	}
@@ -710,14 +868,73 @@ func (vm *VM) resolveBeginTarget(keyword string) string {
 	return kw
 }
 
+// Globals returns a copy of the scalar globals. RESULT and RESULTS live in
+// arrays, so their element 0 is mirrored in as well, as Result and
+// ResultString read it.
 func (vm *VM) Globals() map[string]Value {
-	cp := make(map[string]Value, len(vm.globals))
+	cp := make(map[string]Value, len(vm.globals)+2)
 	for k, v := range vm.globals {
 		cp[k] = v
 	}
+	cp["RESULT"] = vm.Result()
+	cp["RESULTS"] = Str(vm.ResultString())
 	return cp
 }
 
+// CompileStats reports the function and statement counts and the parse
+// time of the program this VM was built from.
+func (vm *VM) CompileStats() ast.ProgramStats {
+	return vm.program.Stats
+}
+
+// Result returns RESULT:0 as left by the script.
+func (vm *VM) Result() Value {
+	v, err := vm.getResultVar("RESULT", nil)
+	if err != nil {
+		return Int(0)
+	}
+	return v
+}
+
+// ResultString returns RESULTS:0 as left by the script.
+func (vm *VM) ResultString() string {
+	v, err := vm.getResultVar("RESULTS", nil)
+	if err != nil {
+		return ""
+	}
+	return v.String()
+}
+
+// SetGlobalInt presets a global integer variable, e.g. before Run.
+func (vm *VM) SetGlobalInt(name string, v int64) error {
+	return vm.setVarRef(ast.VarRef{Name: name}, Int(v))
+}
+
+// SetGlobalString presets a global string variable, e.g. before Run.
+func (vm *VM) SetGlobalString(name, s string) error {
+	return vm.setVarRef(ast.VarRef{Name: name}, Str(s))
+}
+
+// SetGlobalArray writes one element of a global array, such as FLAG:10,
+// the same way a script assignment would.
+func (vm *VM) SetGlobalArray(name string, idx []int64, v Value) error {
+	return vm.setVarRef(hostVarRef(name, idx), v)
+}
+
+// GetGlobalArray reads one element of a global array the way a script
+// expression would, including character and CSV fallbacks.
+func (vm *VM) GetGlobalArray(name string, idx []int64) (Value, error) {
+	return vm.getVarRef(hostVarRef(name, idx))
+}
+
+func hostVarRef(name string, idx []int64) ast.VarRef {
+	ref := ast.VarRef{Name: name, Index: make([]ast.Expr, len(idx))}
+	for i, n := range idx {
+		ref.Index[i] = ast.IntLit{Value: n}
+	}
+	return ref
+}
+
 func (vm *VM) SetSaveDir(dir string) {
 	vm.saveDir = dir
 }
@@ -726,6 +943,112 @@ func (vm *VM) SetOutputHook(hook func(Output)) {
 	vm.outputHook = hook
 }
 
+// WithBatch runs fn with output hook delivery held back, then hands the
+// buffered outputs to the hook in one go, as BEGINBATCH/ENDBATCH do.
+func (vm *VM) WithBatch(fn func()) {
+	vm.beginBatch()
+	defer vm.endBatch()
+	fn()
+}
+
+func (vm *VM) beginBatch() {
+	vm.batchDepth++
+}
+
+// endBatch closes one BEGINBATCH level and flushes the buffer once the
+// outermost level ends. Unbalanced calls are ignored.
+func (vm *VM) endBatch() {
+	if vm.batchDepth == 0 {
+		return
+	}
+	vm.batchDepth--
+	if vm.batchDepth == 0 {
+		vm.flushBatch()
+	}
+}
+
+func (vm *VM) flushBatch() {
+	pending := vm.batchOutputs
+	vm.batchOutputs = nil
+	if vm.outputHook == nil {
+		return
+	}
+	for _, out := range pending {
+		vm.outputHook(out)
+	}
+}
+
+// notifyOutputHook passes out to the output hook, or buffers it while a
+// batch is open. A clear inside a batch cancels buffered lines first, so a
+// print-then-clear loop never reaches the hook at all.
+func (vm *VM) notifyOutputHook(out Output) {
+	if vm.outputHook == nil {
+		return
+	}
+	if vm.batchDepth == 0 {
+		vm.outputHook(out)
+		return
+	}
+	if out.ClearLines > 0 {
+		n := min(out.ClearLines, len(vm.batchOutputs))
+		vm.batchOutputs = vm.batchOutputs[:len(vm.batchOutputs)-n]
+		out.ClearLines -= n
+		if out.ClearLines == 0 {
+			return
+		}
+	}
+	vm.batchOutputs = append(vm.batchOutputs, out)
+}
+
+// SetStreamWriter writes every output straight to w instead of retaining
+// it, so Run returns no outputs. Streamed text cannot be taken back:
+// CLEARLINE and REUSELASTLINE become no-ops (the output hook still sees the
+// CLEARLINE), and builtins that inspect the log see it empty. LINECOUNT
+// keeps counting. A nil w restores buffering.
+func (vm *VM) SetStreamWriter(w io.Writer) {
+	vm.streamWriter = w
+	vm.streamColumn = 0
+}
+
+// SetTitleHook registers a callback invoked with the new title whenever a
+// script runs SETWINDOWTITLE.
+func (vm *VM) SetTitleHook(hook func(string)) {
+	vm.titleHook = hook
+}
+
+// WindowTitle returns the current window title: GAMEBASE.CSV's WINDOWTITLE
+// until a script changes it.
+func (vm *VM) WindowTitle() string {
+	return vm.windowTitle
+}
+
+// ReloadCSV rebuilds the CSV tables from files and re-applies GAMEBASE.CSV.
+// GAMEBASE_* globals keep their current values unless the new files supply
+// non-empty ones, and the save identity follows the new CODE/VERSION. Files
+// other than *.CSV are ignored; a set with none is an error.
+func (vm *VM) ReloadCSV(files map[string]string) error {
+	found := false
+	for file := range files {
+		if csvBaseName(file) != "" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no CSV files to reload")
+	}
+	vm.csv = newCSVStore(files)
+	vm.initSaveIdentity()
+	vm.applyGameMeta(true)
+	return nil
+}
+
+// SetCSVSource sets where RELOADCSV reads CSV files from, typically the game's
+// CSV directory on disk. Without a source RELOADCSV fails.
+func (vm *VM) SetCSVSource(source func() (map[string]string, error)) {
+	vm.csvSource = source
+}
+
 func (vm *VM) SetInputProvider(provider func(InputRequest) (string, bool, error)) {
 	vm.inputProvider = provider
 }
@@ -734,6 +1057,11 @@ func (vm *VM) SetDatSaveFormat(format string) error {
 	format = strings.ToLower(strings.TrimSpace(format))
 	switch format {
 	case "json", "binary", "both":
+		if format != "json" {
+			if err := vm.checkBinarySaveDims(); err != nil {
+				return err
+			}
+		}
 		vm.datSaveFormat = format
 		return nil
 	default:
@@ -741,32 +1069,428 @@ func (vm *VM) SetDatSaveFormat(format string) error {
 	}
 }
 
+// checkBinarySaveDims reports the first saved global #DIM the Emuera binary
+// format can't encode. JSON saves and NODATA arrays have no such limit.
+func (vm *VM) checkBinarySaveDims() error {
+	for _, decl := range vm.program.VarDecls {
+		if decl.NoData || decl.IsRef || len(decl.Dims) <= maxSaveArrayDims {
+			continue
+		}
+		return fmt.Errorf("%s:%d: #DIM %s: %d dimensions declared, binary saves support at most %d",
+			decl.File, decl.Line, strings.ToUpper(decl.Name), len(decl.Dims), maxSaveArrayDims)
+	}
+	return nil
+}
+
 func (vm *VM) DatSaveFormat() string {
 	return vm.datSaveFormat
 }
 
+// SetSaveCompression gzips binary SAVEVAR/SAVECHARA files as they are
+// written. Loading detects compression itself, so plain files stay readable.
+func (vm *VM) SetSaveCompression(enabled bool) {
+	vm.saveCompression = enabled
+}
+
+// SetSaveCompatMode selects how loads treat a unique code that differs from
+// the running game's. "strict" rejects the save; "lenient" loads it anyway and
+// only checks the save version, so script patches don't strand old saves.
+func (vm *VM) SetSaveCompatMode(mode string) error {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "strict", "lenient":
+		vm.saveCompatMode = mode
+		return nil
+	default:
+		return fmt.Errorf("invalid save compat mode %q (use strict|lenient)", mode)
+	}
+}
+
+func (vm *VM) SaveCompatMode() string {
+	return vm.saveCompatMode
+}
+
+// saveUniqueCompatible reports whether a save stamped with unique may be
+// loaded under the current compat mode.
+func (vm *VM) saveUniqueCompatible(unique int64) bool {
+	return unique == vm.saveUniqueCode || vm.saveCompatMode == "lenient"
+}
+
+// SetMaxCallDepth limits nested function calls; n <= 0 disables the guard.
+func (vm *VM) SetMaxCallDepth(n int) {
+	vm.maxCallDepth = n
+}
+
+// SetStepLimit bounds the statements a single Run may execute before it
+// fails with a step limit error; n <= 0 disables the budget.
+func (vm *VM) SetStepLimit(n int64) {
+	vm.execStepLimit = n
+}
+
+// SetMaxLoopIterations caps the iterations of any single WHILE/DO/FOR/REPEAT
+// loop; n <= 0 (the default) leaves loops bounded only by the step limit.
+func (vm *VM) SetMaxLoopIterations(n int64) {
+	vm.maxLoopIter = n
+}
+
+func (vm *VM) SetHTMLEscapeOutput(enabled bool) {
+	vm.htmlEscapeOutput = enabled
+}
+
+// SetClock replaces the wall clock behind GETTIME, GETMILLISECOND,
+// MARKTIME/DELTATIME and save timestamps; nil restores time.Now.
+func (vm *VM) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	vm.clock = now
+}
+
+// now reads the VM clock, falling back to time.Now for bare VMs built by the
+// save codec.
+func (vm *VM) now() time.Time {
+	if vm.clock == nil {
+		return time.Now()
+	}
+	return vm.clock()
+}
+
+// SetStrictFormExpansion makes PRINTFORM-style expansion fail instead of
+// silently truncating when a template never reaches a fixed point.
+func (vm *VM) SetStrictFormExpansion(enabled bool) {
+	vm.strictForms = enabled
+}
+
+// SetCollapseSpaces folds runs of spaces in emitted text into one space,
+// leaving leading padding intact so right-aligned fields keep their shape.
+func (vm *VM) SetCollapseSpaces(enabled bool) {
+	vm.collapseSpaces = enabled
+}
+
+// SetNumberFormat sets the digit-group and decimal separators used by
+// MONEYSTR. Digits are not grouped until a non-empty groupSep is set.
+func (vm *VM) SetNumberFormat(groupSep, decimalSep string) {
+	vm.groupSep = groupSep
+	vm.decimalSep = decimalSep
+}
+
+// formatNumber renders v with the separators chosen by SetNumberFormat,
+// grouping the integer part in threes.
+func (vm *VM) formatNumber(v Value) string {
+	text := strconv.FormatInt(v.Int64(), 10)
+	if v.Kind() == FloatKind {
+		text = strconv.FormatFloat(v.Float64(), 'f', -1, 64)
+	}
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	intPart, frac, hasFrac := strings.Cut(text, ".")
+	if vm.groupSep != "" {
+		var b strings.Builder
+		for i, r := range intPart {
+			if i > 0 && (len(intPart)-i)%3 == 0 {
+				b.WriteString(vm.groupSep)
+			}
+			b.WriteRune(r)
+		}
+		intPart = b.String()
+	}
+	if hasFrac {
+		return sign + intPart + vm.decimalSep + frac
+	}
+	return sign + intPart
+}
+
+// RandEventKind tells what a RandLog entry records.
+type RandEventKind int
+
+const (
+	// RandDraw is a value drawn by RAND, PRINTDATA or DUMPRAND.
+	RandDraw RandEventKind = iota
+	// RandSeed is a seed installed by INITRAND or RANDOMIZE, or by
+	// QUICKLOAD of a quicksave that predates saved generator states.
+	RandSeed
+)
+
+// RandEvent is one entry of RandLog.
+type RandEvent struct {
+	Kind  RandEventKind
+	Value int64
+}
+
+// SetRandLogging records every random draw and every seed installed on the
+// current stream, in call order, for RandLog. QUICKLOAD restores saved
+// generator states without logging them.
+func (vm *VM) SetRandLogging(enabled bool) {
+	vm.randLogging = enabled
+}
+
+// RandLog returns the events recorded during the last Run while rand
+// logging was enabled.
+func (vm *VM) RandLog() []RandEvent {
+	return append([]RandEvent(nil), vm.randLog...)
+}
+
+// builtinColorNames are the color names understood by COLOR_FROMNAME and
+// SETCOLORBYNAME without any registration.
+var builtinColorNames = map[string]int64{
+	"BLACK": 0x000000, "WHITE": 0xFFFFFF, "RED": 0xFF0000, "GREEN": 0x00FF00,
+	"BLUE": 0x0000FF, "YELLOW": 0xFFFF00, "CYAN": 0x00FFFF, "MAGENTA": 0xFF00FF,
+	"GRAY": 0x808080, "GREY": 0x808080, "ORANGE": 0xFFA500, "PURPLE": 0x800080,
+	"PINK": 0xFFC0CB, "BROWN": 0xA52A2A,
+}
+
+// SetNamedColor registers a game-defined color name (case-insensitive) that
+// takes precedence over the built-in names.
+func (vm *VM) SetNamedColor(name string, rgb int64) {
+	vm.namedColors[strings.ToUpper(strings.TrimSpace(name))] = rgb & 0xFFFFFF
+}
+
+func (vm *VM) lookupNamedColor(name string) (int64, bool) {
+	key := strings.ToUpper(strings.TrimSpace(name))
+	if c, ok := vm.namedColors[key]; ok {
+		return c, true
+	}
+	c, ok := builtinColorNames[key]
+	return c, ok
+}
+
+// SetSysInfo publishes an embedder-defined value readable via SYSINFO; keys
+// are case-insensitive.
+func (vm *VM) SetSysInfo(key, value string) {
+	vm.sysInfo[strings.ToUpper(strings.TrimSpace(key))] = value
+}
+
+// SetLevelTable registers the ascending thresholds used by GETPALAMLV and
+// GETEXPLV when name is passed as their table argument.
+func (vm *VM) SetLevelTable(name string, thresholds []int64) {
+	vm.levelTables[strings.ToUpper(strings.TrimSpace(name))] = append([]int64(nil), thresholds...)
+}
+
+// levelTable resolves a threshold table: registered tables first, then a
+// CSV table whose rows are "level,threshold".
+func (vm *VM) levelTable(name string) ([]int64, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if levels, ok := vm.levelTables[name]; ok {
+		return levels, true
+	}
+	entries := vm.csv.Entries(name)
+	if len(entries) == 0 {
+		return nil, false
+	}
+	levels := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		th, err := strconv.ParseInt(strings.TrimSpace(e.Name), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		levels = append(levels, th)
+	}
+	return levels, true
+}
+
+// SetMaxLogLines bounds the retained output log; older lines are dropped
+// first. CLEARLINE can only remove lines that are still retained.
+func (vm *VM) SetMaxLogLines(n int) {
+	vm.maxLogLines = n
+}
+
+// OutputLimitPolicy selects what SetMaxOutputs does once the cap is hit.
+type OutputLimitPolicy int
+
+const (
+	// OutputDropOldest discards the oldest retained lines, like SetMaxLogLines.
+	OutputDropOldest OutputLimitPolicy = iota
+	// OutputLimitError fails the running statement instead of growing further.
+	OutputLimitError
+)
+
+// SetMaxOutputs caps the retained output buffer at n entries; n <= 0
+// removes the cap. It is independent of SetMaxLogLines: when both drop
+// lines, the smaller limit wins.
+func (vm *VM) SetMaxOutputs(n int, policy OutputLimitPolicy) {
+	vm.maxOutputs = n
+	vm.outputPolicy = policy
+}
+
+// retainLimit is the number of output entries kept before the oldest are
+// dropped, or 0 when nothing is dropped.
+func (vm *VM) retainLimit() int {
+	limit := vm.maxLogLines
+	if vm.maxOutputs > 0 && vm.outputPolicy == OutputDropOldest && (limit <= 0 || vm.maxOutputs < limit) {
+		limit = vm.maxOutputs
+	}
+	return limit
+}
+
+// emitOutput formats and appends ordinary output, first ending any
+// partially filled PRINTC row.
 func (vm *VM) emitOutput(out Output) {
+	if out.ClearLines <= 0 {
+		vm.endColumnRow()
+	}
+	vm.writeOutput(out)
+}
+
+func (vm *VM) writeOutput(out Output) {
+	if vm.collapseSpaces && out.ClearLines <= 0 {
+		out.Text = collapseInnerSpaces(out.Text)
+	}
+	if vm.ui.TabWidth > 0 && out.ClearLines <= 0 && strings.ContainsRune(out.Text, '\t') {
+		out.Text = expandTabs(out.Text, vm.pendingLineColumns(), vm.ui.TabWidth)
+	}
+	if vm.htmlEscapeOutput && out.ClearLines <= 0 {
+		out.Text = html.EscapeString(out.Text)
+	}
+	vm.writeRawOutput(out)
+}
+
+// collapseInnerSpaces keeps the leading run of spaces and reduces every
+// later run to a single space.
+func collapseInnerSpaces(s string) string {
+	body := strings.TrimLeft(s, " ")
+	if !strings.Contains(body, "  ") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteString(s[:len(s)-len(body)])
+	prevSpace := false
+	for _, r := range body {
+		if r == ' ' && prevSpace {
+			continue
+		}
+		prevSpace = r == ' '
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// pendingLineColumns is the display width already emitted on the current,
+// not yet terminated, line.
+func (vm *VM) pendingLineColumns() int {
+	if vm.streamWriter != nil {
+		return vm.streamColumn
+	}
+	col := 0
+	for i := len(vm.outputs) - 1; i >= 0 && !vm.outputs[i].NewLine; i-- {
+		col += displayColumns(vm.outputs[i].Text)
+	}
+	return col
+}
+
+func displayColumns(s string) int {
+	n := 0
+	for _, r := range s {
+		n += int(sjisByteWidth(r))
+	}
+	return n
+}
+
+// expandTabs replaces each tab with spaces up to the next multiple of width,
+// counting full-width runes as two columns.
+func expandTabs(s string, col, width int) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\t':
+			pad := width - col%width
+			b.WriteString(strings.Repeat(" ", pad))
+			col += pad
+		case '\n':
+			b.WriteRune(r)
+			col = 0
+		default:
+			b.WriteRune(r)
+			col += int(sjisByteWidth(r))
+		}
+	}
+	return b.String()
+}
+
+// emitRawOutput bypasses HTML escaping for commands whose text is already
+// markup (HTML_PRINT) or was escaped when first emitted.
+func (vm *VM) emitRawOutput(out Output) {
+	if out.ClearLines <= 0 {
+		vm.endColumnRow()
+	}
+	vm.writeRawOutput(out)
+}
+
+func (vm *VM) writeRawOutput(out Output) {
+	if out.ClearLines <= 0 {
+		out.Color = vm.ui.Color
+		out.BgColor = vm.ui.BgColor
+		out.Bold = vm.ui.Bold
+		out.Italic = vm.ui.Italic
+		out.Align = vm.ui.Align
+	}
 	if out.ClearLines > 0 {
 		n := out.ClearLines
 		if n > len(vm.outputs) {
 			n = len(vm.outputs)
 		}
 		vm.outputs = vm.outputs[:len(vm.outputs)-n]
-		if vm.outputHook != nil {
-			vm.outputHook(out)
+		vm.notifyOutputHook(out)
+		return
+	}
+	if vm.streamWriter != nil {
+		vm.writeStream(out)
+		vm.notifyOutputHook(out)
+		return
+	}
+	if vm.maxOutputs > 0 && vm.outputPolicy == OutputLimitError && len(vm.outputs) >= vm.maxOutputs {
+		if vm.outputErr == nil {
+			vm.outputErr = fmt.Errorf("output limit exceeded (%d entries)", vm.maxOutputs)
 		}
 		return
 	}
 	vm.outputs = append(vm.outputs, out)
-	if vm.outputHook != nil {
-		vm.outputHook(out)
+	if limit := vm.retainLimit(); limit > 0 && len(vm.outputs) > limit {
+		drop := len(vm.outputs) - limit
+		vm.outputs = append(vm.outputs[:0], vm.outputs[drop:]...)
+		vm.trimmedLines += int64(drop)
+	}
+	vm.notifyOutputHook(out)
+}
+
+func (vm *VM) writeStream(out Output) {
+	text := out.Text
+	if out.NewLine {
+		text += "\n"
+		vm.streamColumn = 0
+	} else {
+		vm.streamColumn += displayColumns(out.Text)
+	}
+	vm.trimmedLines++
+	if _, err := io.WriteString(vm.streamWriter, text); err != nil && vm.outputErr == nil {
+		vm.outputErr = fmt.Errorf("output stream: %w", err)
 	}
 }
 
+// lineCount includes lines already trimmed from the retained log, so
+// LINECOUNT keeps growing even when SetMaxLogLines is in effect.
+func (vm *VM) lineCount() int64 {
+	return vm.trimmedLines + int64(len(vm.outputs))
+}
+
 func (vm *VM) callFunction(name string, args []Value) (execResult, error) {
 	return vm.callFunctionArgs(name, args, nil)
 }
 
+// passedArgCount counts the call arguments that were actually supplied,
+// skipping omitted slots such as the middle of CALL F(1, , 3).
+func passedArgCount(args []Value, missing []bool) int {
+	n := 0
+	for i := range args {
+		if i < len(missing) && missing[i] {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
 func (vm *VM) ensureFunctionState(name string) {
 	name = strings.ToUpper(strings.TrimSpace(name))
 	if name == "" {
@@ -803,6 +1527,9 @@ func (vm *VM) callFunctionArgs(name string, args []Value, missing []bool) (execR
 	if fn == nil {
 		return execResult{}, fmt.Errorf("function %s not found", name)
 	}
+	if vm.maxCallDepth > 0 && len(vm.stack) >= vm.maxCallDepth {
+		return execResult{}, fmt.Errorf("call depth limit exceeded (%d) calling %s", vm.maxCallDepth, name)
+	}
 	stateKey := functionStateKey(fn.Name, -1)
 	vm.ensureFunctionState(stateKey)
 
@@ -812,6 +1539,7 @@ func (vm *VM) callFunctionArgs(name string, args []Value, missing []bool) (execR
 		lArrays:  map[string]*ArrayVar{},
 		lRefDecl: map[string]bool{},
 		refs:     map[string]ast.VarRef{},
+		argc:     passedArgCount(args, missing),
 	}
 	persistLocalArrays := map[string]struct{}{}
 
@@ -829,6 +1557,9 @@ func (vm *VM) callFunctionArgs(name string, args []Value, missing []bool) (execR
 		target := vm.normalizeFuncArgTarget(arg, i)
 
 		assignArg := func(v Value) error {
+			if isResultLikeName(target.Name) {
+				return vm.setVarRef(target, v)
+			}
 			if len(target.Index) == 0 {
 				fr.locals[target.Name] = v
 				if target.Name == "ARG" || target.Name == "ARGS" {
@@ -885,7 +1616,7 @@ func (vm *VM) callFunctionArgs(name string, args []Value, missing []bool) (execR
 
 	for _, decl := range fn.VarDecls {
 		name := strings.ToUpper(strings.TrimSpace(decl.Name))
-		if name == "" {
+		if name == "" || isResultLikeName(name) {
 			continue
 		}
 		switch decl.Scope {
@@ -895,7 +1626,11 @@ func (vm *VM) callFunctionArgs(name string, args []Value, missing []bool) (execR
 				continue
 			}
 			if vm.gArrays[name] == nil {
-				vm.gArrays[name] = newArrayVar(decl.IsString, decl.IsDynamic, decl.Dims)
+				arr, err := vm.newDeclaredArray(decl)
+				if err != nil {
+					return execResult{}, fmt.Errorf("%s #DIM %s: %w", fn.Name, name, err)
+				}
+				vm.gArrays[name] = arr
 			}
 		default:
 			if decl.IsRef {
@@ -908,7 +1643,11 @@ func (vm *VM) callFunctionArgs(name string, args []Value, missing []bool) (execR
 				continue
 			}
 			if fr.lArrays[name] == nil {
-				fr.lArrays[name] = newArrayVar(decl.IsString, decl.IsDynamic, decl.Dims)
+				arr, err := vm.newDeclaredArray(decl)
+				if err != nil {
+					return execResult{}, fmt.Errorf("%s #DIM %s: %w", fn.Name, name, err)
+				}
+				fr.lArrays[name] = arr
 			}
 		}
 	}
@@ -924,6 +1663,46 @@ func (vm *VM) callFunctionArgs(name string, args []Value, missing []bool) (execR
 	return res, nil
 }
 
+// newDeclaredArray allocates the array for a #DIM/#DIMS declaration and
+// fills its leading elements from the declaration's initializer list.
+func (vm *VM) newDeclaredArray(decl ast.VarDecl) (*ArrayVar, error) {
+	arr := newArrayVar(decl.IsString, decl.IsDynamic, decl.Dims)
+	for i, expr := range decl.Init {
+		v, err := vm.evalExpr(expr)
+		if err != nil {
+			return nil, err
+		}
+		if err := arr.Set([]int64{int64(i)}, v); err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+// runCaseBodies runs the matched branch and keeps going through following
+// bodies while each finished branch is marked as falling through.
+func (vm *VM) runCaseBodies(s ast.SelectCaseStmt, from int) (execResult, error) {
+	for i := from; i < len(s.Branches); i++ {
+		res, err := vm.runThunk(s.Branches[i].Body)
+		if err != nil || res.kind != resultNone || !s.Branches[i].Fallthrough {
+			return res, err
+		}
+	}
+	return vm.runThunk(s.Else)
+}
+
+func (vm *VM) checkRunCancelled() error {
+	if vm.runCtx == nil {
+		return nil
+	}
+	select {
+	case <-vm.runCtx.Done():
+		return fmt.Errorf("run cancelled: %w", vm.runCtx.Err())
+	default:
+		return nil
+	}
+}
+
 func (vm *VM) bumpExecStep(reason string) error {
 	if vm.execStepLimit <= 0 {
 		return nil
@@ -951,6 +1730,17 @@ func (vm *VM) bumpExecStep(reason string) error {
 	return fmt.Errorf("execution step limit exceeded (%d) at %s (fn=%s pc=%d stack=%s)", vm.execStepLimit, reason, fnName, vm.execPC, stackTrace)
 }
 
+func (vm *VM) checkLoopIterations(kind string, iter int64) error {
+	if vm.maxLoopIter <= 0 || iter <= vm.maxLoopIter {
+		return nil
+	}
+	fnName := "<global>"
+	if fr := vm.currentFrame(); fr != nil && fr.fn != nil {
+		fnName = fr.fn.Name
+	}
+	return fmt.Errorf("%s loop exceeded %d iterations (fn=%s)", kind, vm.maxLoopIter, fnName)
+}
+
 func (vm *VM) runThunk(thunk *ast.Thunk) (execResult, error) {
 	prevThunk := vm.execThunk
 	prevPC := vm.execPC
@@ -962,21 +1752,28 @@ func (vm *VM) runThunk(thunk *ast.Thunk) (execResult, error) {
 	}()
 
 	for pc := 0; pc < len(thunk.Statements); pc++ {
+		if err := vm.checkRunCancelled(); err != nil {
+			return execResult{}, err
+		}
 		if err := vm.bumpExecStep("statement"); err != nil {
 			return execResult{}, err
 		}
 		vm.execPC = pc
 		stmt := thunk.Statements[pc]
 		res, err := vm.runStatement(stmt)
+		if err == nil && vm.outputErr != nil {
+			err, vm.outputErr = vm.outputErr, nil
+		}
 		if err != nil {
-			fnName := ""
+			rerr := &RuntimeError{PC: pc, Stmt: stmt, Err: err}
 			if fr := vm.currentFrame(); fr != nil && fr.fn != nil {
-				fnName = fr.fn.Name
+				rerr.Func = fr.fn.Name
 			}
-			if fnName == "" {
-				return execResult{}, fmt.Errorf("pc %d (%T): %w", pc, stmt, err)
+			if pos, ok := thunk.PosAt(pc); ok {
+				rerr.File = pos.File
+				rerr.Line = pos.Line
 			}
-			return execResult{}, fmt.Errorf("%s pc %d (%T): %w", fnName, pc, stmt, err)
+			return execResult{}, rerr
 		}
 		if res.kind == resultGoto {
 			idx, ok := thunk.LabelMap[strings.ToUpper(res.label)]
@@ -1019,6 +1816,10 @@ func (vm *VM) runStatement(stmt ast.Statement) (execResult, error) {
 	case ast.AssignStmt:
 		if s.Op == "=" && len(s.Target.Index) == 0 {
 			if sourceRef, ok := s.Expr.(ast.VarRef); ok && vm.isRefDeclared(s.Target.Name) {
+				sourceRef, err := vm.freezeVarRefIndex(sourceRef)
+				if err != nil {
+					return execResult{}, err
+				}
 				vm.setRefBinding(strings.ToUpper(s.Target.Name), sourceRef)
 				return execResult{kind: resultNone}, nil
 			}
@@ -1098,18 +1899,22 @@ func (vm *VM) runStatement(stmt ast.Statement) (execResult, error) {
 		if err != nil {
 			return execResult{}, err
 		}
-		for _, br := range s.Branches {
-			ok, err := vm.matchCaseConditions(target, br.Conditions)
+		csvBase := ""
+		if ref, ok := s.Target.(ast.VarRef); ok {
+			csvBase = csvBaseFromVarName(ref.Name)
+		}
+		for i, br := range s.Branches {
+			ok, err := vm.matchCaseConditions(target, csvBase, br.Conditions, br.MatchAll)
 			if err != nil {
 				return execResult{}, err
 			}
 			if ok {
-				return vm.runThunk(br.Body)
+				return vm.runCaseBodies(s, i)
 			}
 		}
 		return vm.runThunk(s.Else)
 	case ast.WhileStmt:
-		for {
+		for iter := int64(1); ; iter++ {
 			if err := vm.bumpExecStep("while-loop"); err != nil {
 				return execResult{}, err
 			}
@@ -1120,6 +1925,9 @@ func (vm *VM) runStatement(stmt ast.Statement) (execResult, error) {
 			if !cond.Truthy() {
 				return execResult{kind: resultNone}, nil
 			}
+			if err := vm.checkLoopIterations("WHILE", iter); err != nil {
+				return execResult{}, err
+			}
 			res, err := vm.runThunk(s.Body)
 			if err != nil {
 				return execResult{}, err
@@ -1136,10 +1944,13 @@ func (vm *VM) runStatement(stmt ast.Statement) (execResult, error) {
 			}
 		}
 	case ast.DoWhileStmt:
-		for {
+		for iter := int64(1); ; iter++ {
 			if err := vm.bumpExecStep("do-while-loop"); err != nil {
 				return execResult{}, err
 			}
+			if err := vm.checkLoopIterations("DO", iter); err != nil {
+				return execResult{}, err
+			}
 			res, err := vm.runThunk(s.Body)
 			if err != nil {
 				return execResult{}, err
@@ -1173,6 +1984,9 @@ func (vm *VM) runStatement(stmt ast.Statement) (execResult, error) {
 			if err := vm.bumpExecStep("repeat-loop"); err != nil {
 				return execResult{}, err
 			}
+			if err := vm.checkLoopIterations("REPEAT", i+1); err != nil {
+				return execResult{}, err
+			}
 			res, err := vm.runThunk(s.Body)
 			if err != nil {
 				return execResult{}, err
@@ -1213,7 +2027,7 @@ func (vm *VM) runStatement(stmt ast.Statement) (execResult, error) {
 		if err := vm.setVarRef(target, Int(initVal.Int64())); err != nil {
 			return execResult{}, err
 		}
-		for {
+		for iter := int64(1); ; iter++ {
 			if err := vm.bumpExecStep("for-loop"); err != nil {
 				return execResult{}, err
 			}
@@ -1226,6 +2040,9 @@ func (vm *VM) runStatement(stmt ast.Statement) (execResult, error) {
 			if (step > 0 && cur >= limit) || (step < 0 && cur <= limit) {
 				return execResult{kind: resultNone}, nil
 			}
+			if err := vm.checkLoopIterations("FOR", iter); err != nil {
+				return execResult{}, err
+			}
 
 			res, err := vm.runThunk(s.Body)
 			if err != nil {
@@ -1315,65 +2132,78 @@ func (vm *VM) runStatement(stmt ast.Statement) (execResult, error) {
 	}
 }
 
-func (vm *VM) matchCaseConditions(target Value, conditions []ast.CaseCondition) (bool, error) {
+// matchCaseConditions reports whether target satisfies any of conditions,
+// or every one of them when all is set (a CASE line starting with "&").
+func (vm *VM) matchCaseConditions(target Value, csvBase string, conditions []ast.CaseCondition, all bool) (bool, error) {
 	for _, cond := range conditions {
-		switch cond.Kind {
-		case "equal":
-			v, err := vm.evalExpr(cond.Expr)
-			if err != nil {
-				return false, err
-			}
-			if valueEqual(target, v) {
-				return true, nil
-			}
-		case "range":
-			from, err := vm.evalExpr(cond.From)
-			if err != nil {
-				return false, err
-			}
-			to, err := vm.evalExpr(cond.To)
-			if err != nil {
-				return false, err
-			}
-			tv := target.Int64()
-			if from.Int64() <= tv && tv <= to.Int64() {
-				return true, nil
-			}
-		case "compare":
-			v, err := vm.evalExpr(cond.Expr)
-			if err != nil {
-				return false, err
-			}
-			t := target.Int64()
-			r := v.Int64()
-			switch cond.Op {
-			case "<":
-				if t < r {
-					return true, nil
-				}
-			case "<=":
-				if t <= r {
-					return true, nil
-				}
-			case ">":
-				if t > r {
-					return true, nil
-				}
-			case ">=":
-				if t >= r {
-					return true, nil
-				}
-			}
+		ok, err := vm.matchCaseCondition(target, csvBase, cond)
+		if err != nil {
+			return false, err
+		}
+		if ok != all {
+			return ok, nil
+		}
+	}
+	return all && len(conditions) > 0, nil
+}
+
+func (vm *VM) matchCaseCondition(target Value, csvBase string, cond ast.CaseCondition) (bool, error) {
+	switch cond.Kind {
+	case "equal":
+		v, err := vm.evalCaseExpr(csvBase, cond.Expr)
+		if err != nil {
+			return false, err
+		}
+		return valueEqual(target, v), nil
+	case "range":
+		from, err := vm.evalCaseExpr(csvBase, cond.From)
+		if err != nil {
+			return false, err
+		}
+		to, err := vm.evalCaseExpr(csvBase, cond.To)
+		if err != nil {
+			return false, err
+		}
+		return compareValues(from, target) <= 0 && compareValues(target, to) <= 0, nil
+	case "compare":
+		v, err := vm.evalCaseExpr(csvBase, cond.Expr)
+		if err != nil {
+			return false, err
+		}
+		c := compareValues(target, v)
+		switch cond.Op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		case ">=":
+			return c >= 0, nil
 		}
 	}
 	return false, nil
 }
 
+// evalCaseExpr resolves a bare CSV name used as a CASE value to its ID,
+// preferring the table behind the SELECTCASE subject.
+func (vm *VM) evalCaseExpr(csvBase string, e ast.Expr) (Value, error) {
+	if ref, ok := e.(ast.VarRef); ok && len(ref.Index) == 0 && !vm.symbolExists(ref.Name) {
+		if id, ok := vm.resolveNamedCSVIndex(csvBase, ref); ok {
+			return Int(id), nil
+		}
+		if id, ok := vm.csv.FindAnyID(ref.Name); ok {
+			return Int(id), nil
+		}
+	}
+	return vm.evalExpr(e)
+}
+
 func (vm *VM) pickDataItemText(items []ast.DataItem) (string, error) {
 	if len(items) == 0 {
 		return "", nil
 	}
-	idx := vm.rng.Intn(len(items))
+	idx := vm.randIntn(len(items))
 	item := items[idx]
 	switch item.Kind {
 	case "dataform":
@@ -1389,42 +2219,62 @@ func valueEqual(a, b Value) bool {
 	if a.Kind() == StringKind || b.Kind() == StringKind {
 		return a.String() == b.String()
 	}
+	if a.Kind() == FloatKind || b.Kind() == FloatKind {
+		return a.Float64() == b.Float64()
+	}
 	return a.Int64() == b.Int64()
 }
 
+// compareValues orders a and b like valueEqual matches them: lexically when
+// either side is a string, numerically otherwise.
+func compareValues(a, b Value) int {
+	if a.Kind() == StringKind || b.Kind() == StringKind {
+		return strings.Compare(a.String(), b.String())
+	}
+	if a.Kind() == FloatKind || b.Kind() == FloatKind {
+		return cmp.Compare(a.Float64(), b.Float64())
+	}
+	return cmp.Compare(a.Int64(), b.Int64())
+}
+
 func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 	name := strings.ToUpper(strings.TrimSpace(s.Name))
 	arg := strings.TrimSpace(s.Arg)
 
+	if name == "PRINTIMG" {
+		return vm.execPrintImg(arg)
+	}
+	if name == "PRINTCPERLINE" {
+		return vm.execPrintCPerLine(arg)
+	}
 	if strings.HasPrefix(name, "PRINT") || strings.HasPrefix(name, "DEBUGPRINT") {
 		text, err := vm.evalCommandPrint(name, arg)
 		if err != nil {
 			return execResult{}, err
 		}
+		out := Output{Text: text}
+		if strings.Contains(name, "BUTTON") {
+			out.IsButton = true
+			out.ButtonValue, err = vm.evalPrintButtonValue(arg)
+			if err != nil {
+				return execResult{}, err
+			}
+		}
 		newLine := s.PrintNewLine || shouldNewlineOnPrint(name)
 		waitInput := s.PrintWait || shouldWaitOnPrint(name)
 		if !vm.ui.SkipDisp {
 			isCol := isColumnPrint(name)
 			if isCol {
-				paddedText := formatPrintField(text, vm.ui.PrintCLength, "LEFT")
-				// Keep at least one visible separator between column cells even when
-				// content length reaches/exceeds the configured column width.
-				if !strings.HasSuffix(paddedText, " ") {
-					paddedText += " "
-				}
-				vm.printCCounter++
-				if vm.printCCounter >= int(vm.ui.PrintCPL) {
-					vm.emitOutput(Output{Text: paddedText, NewLine: true})
-					vm.printCCounter = 0
-				} else {
-					vm.emitOutput(Output{Text: paddedText, NewLine: false})
-				}
+				vm.emitColumnCell(name, out)
 			} else {
-				vm.emitOutput(Output{Text: text, NewLine: newLine})
+				out.NewLine = newLine
+				vm.emitOutput(out)
 				if newLine {
 					vm.printCCounter = 0
 				}
 			}
+			// The W variants wait once per statement, after the whole text
+			// (embedded newlines included) has been emitted.
 			if waitInput {
 				if err := vm.waitAfterPrint(); err != nil {
 					return execResult{}, err
@@ -1444,9 +2294,9 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 			}
 		}
 		if vm.ui.SkipDisp {
-			vm.globals["RESULT"] = Int(1)
+			vm.setResultVar("RESULT", Int(1))
 		} else {
-			vm.globals["RESULT"] = Int(0)
+			vm.setResultVar("RESULT", Int(0))
 		}
 		return execResult{kind: resultNone}, nil
 	case "INPUT", "ONEINPUT", "TINPUT", "TONEINPUT", "BINPUT", "ONEBINPUT":
@@ -1454,27 +2304,40 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 	case "INPUTS", "ONEINPUTS", "TINPUTS", "TONEINPUTS", "BINPUTS", "ONEBINPUTS":
 		return vm.execInputStringLike(name, arg)
 	case "GETTIME":
-		vm.globals["RESULT"] = Int(time.Now().Unix())
+		vm.setResultVar("RESULT", Int(vm.clock().Unix()))
 		return execResult{kind: resultNone}, nil
 	case "GETSECOND":
-		vm.globals["RESULT"] = Int(int64(time.Now().Second()))
+		vm.setResultVar("RESULT", Int(int64(vm.clock().Second())))
 		return execResult{kind: resultNone}, nil
 	case "GETMILLISECOND":
-		vm.globals["RESULT"] = Int(int64(time.Now().Nanosecond() / 1e6))
+		vm.setResultVar("RESULT", Int(int64(vm.clock().Nanosecond()/1e6)))
+		return execResult{kind: resultNone}, nil
+	case "MARKTIME":
+		vm.markTime = vm.clock()
+		return execResult{kind: resultNone}, nil
+	case "DELTATIME":
+		if vm.markTime.IsZero() {
+			vm.setResultVar("RESULT", Int(0))
+		} else {
+			vm.setResultVar("RESULT", Int(vm.clock().Sub(vm.markTime).Milliseconds()))
+		}
 		return execResult{kind: resultNone}, nil
 	case "RANDOMIZE":
-		vm.rng.Seed(time.Now().UnixNano())
+		vm.seedRand(time.Now().UnixNano())
 		return execResult{kind: resultNone}, nil
 	case "INITRAND":
 		if arg != "" {
 			v, err := vm.evalLooseExpr(arg)
 			if err == nil {
-				vm.rng.Seed(v.Int64())
+				vm.seedRand(v.Int64())
 			}
 		}
 		return execResult{kind: resultNone}, nil
+	case "RANDSTREAM":
+		vm.selectRandStream(arg)
+		return execResult{kind: resultNone}, nil
 	case "DUMPRAND":
-		vm.globals["RESULT"] = Int(vm.rng.Int63())
+		vm.setResultVar("RESULT", Int(vm.randInt63()))
 		return execResult{kind: resultNone}, nil
 	case "RESTART":
 		return execResult{kind: resultRestart}, nil
@@ -1524,15 +2387,21 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 		return vm.execDeleteData(arg)
 	case "CHKDATA":
 		return vm.execCheckData(arg)
+	case "CHKDATACOMPAT":
+		return vm.execCheckDataCompat(arg)
 	case "SAVEGAME":
 		return vm.execSaveGame(arg)
 	case "LOADGAME":
 		return vm.execLoadGame(arg)
+	case "QUICKSAVE":
+		return vm.execQuickSave(arg)
+	case "QUICKLOAD":
+		return vm.execQuickLoad(arg)
 	case "SAVEGLOBAL":
 		if err := vm.saveGlobals("global"); err != nil {
 			return execResult{}, err
 		}
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "LOADGLOBAL":
 		ok, err := vm.loadGlobals("global")
@@ -1540,9 +2409,9 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 			return execResult{}, err
 		}
 		if ok {
-			vm.globals["RESULT"] = Int(1)
+			vm.setResultVar("RESULT", Int(1))
 		} else {
-			vm.globals["RESULT"] = Int(0)
+			vm.setResultVar("RESULT", Int(0))
 		}
 		return execResult{kind: resultNone}, nil
 	case "VARSET":
@@ -1575,39 +2444,66 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 		return vm.execArrayCopy(arg)
 	case "ARRAYSORT":
 		return vm.execArraySort(arg)
+	case "COMPACTARRAY":
+		return vm.execCompactArray(arg)
 	case "DRAWLINE", "CUSTOMDRAWLINE", "DRAWLINEFORM":
 		return vm.execDrawLine(name, arg)
 	case "CLEARLINE":
 		return vm.execClearLine(arg)
 	case "REUSELASTLINE":
 		return vm.execReuseLastLine()
+	case "GETLINECOUNT":
+		vm.setResultVar("RESULT", Int(vm.lineCount()))
+		return execResult{kind: resultNone}, nil
 	case "ALIGNMENT":
 		return vm.execAlignment(arg)
 	case "CURRENTALIGN":
-		vm.globals["RESULT"] = Str(vm.ui.Align)
+		vm.setResultVar("RESULT", Str(vm.ui.Align))
+		return execResult{kind: resultNone}, nil
+	case "BEGINBATCH":
+		vm.beginBatch()
+		return execResult{kind: resultNone}, nil
+	case "ENDBATCH":
+		vm.endBatch()
+		return execResult{kind: resultNone}, nil
+	case "RELOADCSV":
+		if vm.csvSource == nil {
+			return execResult{}, fmt.Errorf("RELOADCSV: no CSV source set")
+		}
+		files, err := vm.csvSource()
+		if err != nil {
+			return execResult{}, fmt.Errorf("RELOADCSV: %w", err)
+		}
+		if err := vm.ReloadCSV(files); err != nil {
+			return execResult{}, fmt.Errorf("RELOADCSV: %w", err)
+		}
 		return execResult{kind: resultNone}, nil
 	case "REDRAW":
 		return vm.execRedraw(arg)
 	case "CURRENTREDRAW":
 		if vm.ui.Redraw {
-			vm.globals["RESULT"] = Int(1)
+			vm.setResultVar("RESULT", Int(1))
 		} else {
-			vm.globals["RESULT"] = Int(0)
+			vm.setResultVar("RESULT", Int(0))
 		}
 		return execResult{kind: resultNone}, nil
 	case "SKIPDISP", "MOUSESKIP", "NOSKIP", "ENDNOSKIP":
 		return vm.execSkipDisp(arg)
 	case "ISSKIP":
 		if vm.ui.SkipDisp {
-			vm.globals["RESULT"] = Int(1)
+			vm.setResultVar("RESULT", Int(1))
 		} else {
-			vm.globals["RESULT"] = Int(0)
+			vm.setResultVar("RESULT", Int(0))
 		}
 		return execResult{kind: resultNone}, nil
-	case "SETCOLOR", "SETCOLORBYNAME":
+	case "SETCOLOR":
 		return vm.execSetColor(arg)
-	case "SETBGCOLOR", "SETBGCOLORBYNAME":
+	case "SETCOLORBYNAME":
+		return vm.execSetColorByName(arg, false)
+	case "SETBGCOLOR":
 		return vm.execSetBgColor(arg)
+	case "SETBGCOLORBYNAME":
+		return vm.execSetColorByName(arg, true)
 	case "RESETCOLOR":
 		vm.ui.Color = "FFFFFF"
 		return execResult{kind: resultNone}, nil
@@ -1615,29 +2511,29 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 		vm.ui.BgColor = "000000"
 		return execResult{kind: resultNone}, nil
 	case "GETCOLOR":
-		vm.globals["RESULT"] = Str(vm.ui.Color)
+		vm.setResultVar("RESULT", Str(vm.ui.Color))
 		return execResult{kind: resultNone}, nil
 	case "GETBGCOLOR":
-		vm.globals["RESULT"] = Str(vm.ui.BgColor)
+		vm.setResultVar("RESULT", Str(vm.ui.BgColor))
 		return execResult{kind: resultNone}, nil
 	case "GETDEFCOLOR":
-		vm.globals["RESULT"] = Str("FFFFFF")
+		vm.setResultVar("RESULT", Str("FFFFFF"))
 		return execResult{kind: resultNone}, nil
 	case "GETDEFBGCOLOR":
-		vm.globals["RESULT"] = Str("000000")
+		vm.setResultVar("RESULT", Str("000000"))
 		return execResult{kind: resultNone}, nil
 	case "GETFOCUSCOLOR":
-		vm.globals["RESULT"] = Str(vm.ui.FocusColor)
+		vm.setResultVar("RESULT", Str(vm.ui.FocusColor))
 		return execResult{kind: resultNone}, nil
 	case "SETFONT":
 		vm.ui.Font = strings.TrimSpace(arg)
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "GETFONT":
-		vm.globals["RESULT"] = Str(vm.ui.Font)
+		vm.setResultVar("RESULT", Str(vm.ui.Font))
 		return execResult{kind: resultNone}, nil
 	case "CHKFONT":
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "FONTBOLD":
 		vm.ui.Bold = true
@@ -1649,18 +2545,38 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 		vm.ui.Bold = false
 		vm.ui.Italic = false
 		return execResult{kind: resultNone}, nil
-	case "FONTSTYLE":
-		return vm.execFontStyle(arg)
-	case "PRINTCPERLINE":
-		return vm.execPrintCPerLine(arg)
+	case "FONTSTYLE":
+		return vm.execFontStyle(arg)
+	case "SETWINDOWTITLE":
+		v, err := vm.evalLooseExpr(arg)
+		if err != nil {
+			return execResult{}, err
+		}
+		vm.windowTitle = v.String()
+		if vm.titleHook != nil {
+			vm.titleHook(vm.windowTitle)
+		}
+		return execResult{kind: resultNone}, nil
+	case "TABWIDTH":
+		return vm.execTabWidth(arg)
+	case "FORMWIDTH":
+		text, err := vm.evalPrintForm(arg)
+		if err != nil {
+			return execResult{}, err
+		}
+		vm.setResultVar("RESULT", Int(int64(displayColumns(text))))
+		return execResult{kind: resultNone}, nil
+	case "SELECTCHARA":
+		return vm.execSelectChara(arg)
 	case "ADDCHARA", "ADDDEFCHARA", "ADDVOIDCHARA", "ADDSPCHARA":
-		return vm.execAddChara(arg)
+		return vm.execAddChara(name, arg)
 	case "DELCHARA":
 		return vm.execDelChara(arg)
 	case "DELALLCHARA":
 		vm.characters = nil
+		vm.selectedChara = -1
 		vm.refreshCharacterGlobals()
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "GETCHARA":
 		return vm.execGetChara(arg)
@@ -1672,7 +2588,7 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 		return vm.execSwapChara(arg)
 	case "SORTCHARA":
 		vm.sortCharacters()
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "COPYCHARA":
 		return vm.execCopyChara(arg, false)
@@ -1681,20 +2597,20 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 	case "PICKUPCHARA":
 		return vm.execPickupChara(arg)
 	case "ISACTIVE":
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "MOUSEX", "MOUSEY":
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	case "OUTPUTLOG":
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "SAVENOS":
-		vm.globals["RESULT"] = Int(20)
+		vm.setResultVar("RESULT", Int(20))
 		return execResult{kind: resultNone}, nil
 	case "DEBUGCLEAR":
 		vm.outputs = vm.outputs[:0]
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "ASSERT":
 		if strings.TrimSpace(arg) == "" {
@@ -1707,7 +2623,7 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 		if !v.Truthy() {
 			return execResult{}, fmt.Errorf("ASSERT failed")
 		}
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "REF", "REFBYNAME":
 		return vm.execRefBinding(name, arg)
@@ -1715,15 +2631,17 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 		return vm.execResetGlobal()
 	case "RESETDATA":
 		return vm.execResetData()
+	case "RESETCHARA":
+		return vm.execResetChara()
 	case "CATCH":
 		if endIdx, ok := vm.currentCatchEndIndex(); ok {
-			vm.globals["RESULT"] = Int(1)
+			vm.setResultVar("RESULT", Int(1))
 			return execResult{kind: resultJumpIndex, index: endIdx}, nil
 		}
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "ENDCATCH", "FUNC", "ENDFUNC":
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "HTML_PRINT":
 		outText := ""
@@ -1774,9 +2692,9 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 			outText = ""
 		}
 		if strings.TrimSpace(outText) != "" {
-			vm.emitOutput(Output{Text: outText, NewLine: true})
+			vm.emitRawOutput(Output{Text: outText, NewLine: true})
 		}
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "HTML_TAGSPLIT":
 		return vm.execHtmlTagSplit(arg)
@@ -1785,9 +2703,9 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 		result := up.Int64() != 0
 		vm.setVar("UP", Int(0))
 		if result {
-			vm.globals["RESULT"] = Int(1)
+			vm.setResultVar("RESULT", Int(1))
 		} else {
-			vm.globals["RESULT"] = Int(0)
+			vm.setResultVar("RESULT", Int(0))
 		}
 		return execResult{kind: resultNone}, nil
 	case "CUPCHECK":
@@ -1795,22 +2713,22 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 		result := cup.Int64() != 0
 		vm.setVar("CUP", Int(0))
 		if result {
-			vm.globals["RESULT"] = Int(1)
+			vm.setResultVar("RESULT", Int(1))
 		} else {
-			vm.globals["RESULT"] = Int(0)
+			vm.setResultVar("RESULT", Int(0))
 		}
 		return execResult{kind: resultNone}, nil
 	case "RESET_STAIN", "STOPCALLTRAIN", "CBGCLEAR", "CBGCLEARBUTTON", "CBGREMOVEBMAP", "CLEARTEXTBOX", "DOTRAIN", "FORCEKANA", "INPUTMOUSEKEY", "TOOLTIP_SETCOLOR", "TOOLTIP_SETDELAY", "TOOLTIP_SETDURATION", "TOOLTIP_SETFONT", "TOOLTIP_SETFONTSIZE", "TOOLTIP_CUSTOM", "TOOLTIP_FORMAT", "TOOLTIP_IMG":
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "PLAYSOUND", "STOPSOUND", "PLAYBGM", "STOPBGM", "SETSOUNDVOLUME", "SETBGMVOLUME":
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "SETBGIMAGE", "REMOVEBGIMAGE", "CLEARBGIMAGE":
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "SKIPLOG":
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	case "FORCE_QUIT":
 		return execResult{kind: resultQuit}, nil
@@ -1873,9 +2791,9 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 			return execResult{}, err
 		}
 		if methodRes.Kind() == StringKind {
-			vm.globals["RESULTS"] = methodRes
+			vm.setResultVar("RESULTS", methodRes)
 		} else {
-			vm.globals["RESULT"] = methodRes
+			vm.setResultVar("RESULT", methodRes)
 		}
 		return execResult{kind: resultNone}, nil
 	}
@@ -1912,6 +2830,12 @@ func (vm *VM) runCommandStatement(s ast.CommandStmt) (execResult, error) {
 }
 
 func (vm *VM) evalCommandPrint(name, arg string) (string, error) {
+	// PRINTPLAIN prints its argument as written: no %...% or {...}
+	// expansion, so literal percent signs and braces survive.
+	// PRINTPLAINFORM still expands, as in Emuera.
+	if name == "PRINTPLAIN" {
+		return decodeCommandCharSeq(arg), nil
+	}
 	if strings.Contains(name, "BUTTON") {
 		return vm.evalPrintButton(arg)
 	}
@@ -1985,6 +2909,20 @@ func (vm *VM) evalPrintButton(arg string) (string, error) {
 	return s, nil
 }
 
+// evalPrintButtonValue evaluates the second PRINTBUTTON argument, the value
+// a click on the button inputs.
+func (vm *VM) evalPrintButtonValue(arg string) (string, error) {
+	parts := splitTopLevelRuntime(arg, ',')
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+		return "", nil
+	}
+	v, err := vm.evalLooseExpr(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
+
 func (vm *VM) evalPrintForms(arg string) (string, error) {
 	v, err := vm.evalCallArgRaw(arg)
 	if err != nil {
@@ -2012,7 +2950,7 @@ func (vm *VM) evalPrintV(arg string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		b.WriteString(v.String())
+		b.WriteString(FormatValue(v))
 	}
 	return b.String(), nil
 }
@@ -2022,7 +2960,7 @@ func (vm *VM) execSaveGame(arg string) (execResult, error) {
 	if err := vm.saveGlobals(slot); err != nil {
 		return execResult{}, err
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2033,9 +2971,9 @@ func (vm *VM) execLoadGame(arg string) (execResult, error) {
 		return execResult{}, err
 	}
 	if ok {
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 	} else {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 	}
 	return execResult{kind: resultNone}, nil
 }
@@ -2062,7 +3000,7 @@ func (vm *VM) execSaveData(arg string) (execResult, error) {
 	if err := vm.saveGlobals(slot); err != nil {
 		return execResult{}, err
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2083,9 +3021,9 @@ func (vm *VM) execLoadData(arg string) (execResult, error) {
 		return execResult{}, err
 	}
 	if ok {
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 	} else {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 	}
 	return execResult{kind: resultNone}, nil
 }
@@ -2098,7 +3036,7 @@ func (vm *VM) execDeleteData(arg string) (execResult, error) {
 	if err := vm.deleteSave(slot); err != nil {
 		return execResult{}, err
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2112,9 +3050,32 @@ func (vm *VM) execCheckData(arg string) (execResult, error) {
 		return execResult{}, err
 	}
 	if ok {
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 	} else {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
+	}
+	return execResult{kind: resultNone}, nil
+}
+
+// execCheckDataCompat sets RESULT to 1 when the slot matches the current
+// save identity, 0 when it was written by a different game or version,
+// and -1 when the slot does not exist.
+func (vm *VM) execCheckDataCompat(arg string) (execResult, error) {
+	slot, err := vm.evalSlotExpr(arg)
+	if err != nil {
+		return execResult{}, err
+	}
+	exists, compatible, err := vm.checkSaveCompat(slot)
+	if err != nil {
+		return execResult{}, err
+	}
+	switch {
+	case !exists:
+		vm.setResultVar("RESULT", Int(-1))
+	case compatible:
+		vm.setResultVar("RESULT", Int(1))
+	default:
+		vm.setResultVar("RESULT", Int(0))
 	}
 	return execResult{kind: resultNone}, nil
 }
@@ -2160,12 +3121,18 @@ func sanitizeSlot(raw string) string {
 
 func (vm *VM) execCSVCommand(name, arg string) (execResult, error) {
 	base := strings.TrimPrefix(strings.ToUpper(name), "CSV")
+	if base == "ENUM" {
+		return vm.execCSVEnum(arg)
+	}
+	if base == "FILELIST" {
+		return vm.execCSVFileList()
+	}
 	args, err := vm.evalCommandArgs(arg)
 	if err != nil {
 		return execResult{}, err
 	}
 	if len(args) == 0 {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	}
 	id := args[0].Int64()
@@ -2177,19 +3144,56 @@ func (vm *VM) execCSVCommand(name, arg string) (execResult, error) {
 			}
 		}
 		if val, ok := vm.csv.CharaField(id, "CSTR", key); ok {
-			vm.globals["RESULT"] = Str(val)
+			vm.setResultVar("RESULT", Str(val))
 		} else if len(args) >= 3 {
-			vm.globals["RESULT"] = args[2]
+			vm.setResultVar("RESULT", args[2])
 		} else {
-			vm.globals["RESULT"] = Str("")
+			vm.setResultVar("RESULT", Str(""))
 		}
 		return execResult{kind: resultNone}, nil
 	}
 	if val, ok := vm.csv.Name(base, id); ok {
-		vm.globals["RESULT"] = Str(val)
+		vm.setResultVar("RESULT", Str(val))
+	} else {
+		vm.setResultVar("RESULT", Str(""))
+	}
+	return execResult{kind: resultNone}, nil
+}
+
+func (vm *VM) execCSVEnum(arg string) (execResult, error) {
+	raw := strings.TrimSpace(arg)
+	if raw == "" {
+		return execResult{}, fmt.Errorf("CSVENUM requires table name")
+	}
+	base := raw
+	if v, err := vm.evalLooseExpr(raw); err == nil && v.Kind() == StringKind {
+		base = v.String()
+	}
+	entries := vm.csv.Entries(base)
+	arr := newArrayVar(true, true, []int{len(entries) + 1})
+	for i, e := range entries {
+		_ = arr.Set([]int64{int64(i)}, Str(e.Name))
+	}
+	vm.gArrays["RESULTS"] = arr
+	if len(entries) > 0 {
+		vm.setResultVar("RESULTS", Str(entries[0].Name))
 	} else {
-		vm.globals["RESULT"] = Str("")
+		vm.setResultVar("RESULTS", Str(""))
 	}
+	vm.setResultVar("RESULT", Int(int64(len(entries))))
+	return execResult{kind: resultNone}, nil
+}
+
+// execCSVFileList fills RESULTS with the names of the loaded CSV files and
+// sets RESULT to their count.
+func (vm *VM) execCSVFileList() (execResult, error) {
+	files := vm.csv.Files()
+	arr := newArrayVar(true, true, []int{len(files) + 1})
+	for i, name := range files {
+		_ = arr.Set([]int64{int64(i)}, Str(name))
+	}
+	vm.gArrays["RESULTS"] = arr
+	vm.setResultVar("RESULT", Int(int64(len(files))))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2209,7 +3213,7 @@ func (vm *VM) execVarSet(arg string) (execResult, error) {
 		if err := vm.resetVarSetTarget(target); err != nil {
 			return execResult{}, err
 		}
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	}
 
@@ -2234,14 +3238,14 @@ func (vm *VM) execVarSet(arg string) (execResult, error) {
 		if err := vm.varSetRange(target, val, startV.Int64(), end); err != nil {
 			return execResult{}, err
 		}
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	}
 
 	if err := vm.setVarRef(target, val); err != nil {
 		return execResult{}, err
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2261,7 +3265,7 @@ func (vm *VM) execCVarSet(arg string) (execResult, error) {
 	}
 	start := startV.Int64()
 	if start < 0 {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	}
 
@@ -2273,7 +3277,7 @@ func (vm *VM) execCVarSet(arg string) (execResult, error) {
 		}
 		end = endV.Int64()
 		if end < 0 {
-			vm.globals["RESULT"] = Int(0)
+			vm.setResultVar("RESULT", Int(0))
 			return execResult{kind: resultNone}, nil
 		}
 		if end < start {
@@ -2302,7 +3306,7 @@ func (vm *VM) execCVarSet(arg string) (execResult, error) {
 		}
 	}
 
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2314,9 +3318,6 @@ func (vm *VM) resetVarSetTarget(target ast.VarRef) error {
 	if len(target.Index) == 0 {
 		if arr, ok := vm.lookupArray(name); ok {
 			arr.Data = map[string]Value{}
-			if isResultLikeName(name) {
-				vm.globals[name] = arr.defaultValue()
-			}
 			return nil
 		}
 		def, err := vm.defaultValueForVarRef(target)
@@ -2383,9 +3384,6 @@ func (vm *VM) varSetRange(target ast.VarRef, val Value, start, end int64) error
 			return err
 		}
 	}
-	if isResultLikeName(name) && len(prefix) == 0 && start <= 0 && 0 <= end {
-		vm.globals[name] = val
-	}
 	return nil
 }
 
@@ -2453,7 +3451,7 @@ func (vm *VM) execBitMutation(name, arg string) (execResult, error) {
 	if err := vm.setVarRef(target, Int(cur)); err != nil {
 		return execResult{}, err
 	}
-	vm.globals["RESULT"] = Int(cur)
+	vm.setResultVar("RESULT", Int(cur))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2476,9 +3474,9 @@ func (vm *VM) execGetBit(arg string) (execResult, error) {
 	bit := uint(bitVal.Int64())
 	mask := int64(1) << bit
 	if (v.Int64() & mask) != 0 {
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 	} else {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 	}
 	return execResult{kind: resultNone}, nil
 }
@@ -2510,7 +3508,7 @@ func (vm *VM) execTimes(arg string) (execResult, error) {
 	if err := vm.setVarRef(target, Int(res)); err != nil {
 		return execResult{}, err
 	}
-	vm.globals["RESULT"] = Int(res)
+	vm.setResultVar("RESULT", Int(res))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2563,7 +3561,7 @@ func (vm *VM) execSplit(arg string) (execResult, error) {
 			return execResult{}, err
 		}
 	}
-	vm.globals["RESULT"] = Int(int64(len(chunks)))
+	vm.setResultVar("RESULT", Int(int64(len(chunks))))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2605,7 +3603,7 @@ func (vm *VM) execHtmlTagSplit(arg string) (execResult, error) {
 		}
 		_ = arr.Set(idx, Str(p))
 	}
-	vm.globals["RESULT"] = Int(int64(len(parts)))
+	vm.setResultVar("RESULT", Int(int64(len(parts))))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2624,8 +3622,8 @@ func (vm *VM) execEscape(arg string) (execResult, error) {
 	for _, r := range repl {
 		s = strings.ReplaceAll(s, r.old, r.new)
 	}
-	vm.globals["RESULTS"] = Str(s)
-	vm.globals["RESULT"] = Int(int64(len(s)))
+	vm.setResultVar("RESULTS", Str(s))
+	vm.setResultVar("RESULT", Int(int64(len(s))))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2635,9 +3633,10 @@ func (vm *VM) execEncodeToUni(arg string) (execResult, error) {
 		return execResult{}, err
 	}
 	buf := []byte(v.String())
-	vm.globals["RESULT"] = Int(int64(len(buf)))
+	arr := vm.resultArray("RESULT")
+	_ = arr.Set([]int64{0}, Int(int64(len(buf))))
 	for i, b := range buf {
-		vm.globals[fmt.Sprintf("RESULT%d", i+1)] = Int(int64(b))
+		_ = arr.Set([]int64{int64(i + 1)}, Int(int64(b)))
 	}
 	return execResult{kind: resultNone}, nil
 }
@@ -2652,7 +3651,7 @@ func (vm *VM) execPutForm(arg string) (execResult, error) {
 		prev = v.String()
 	}
 	vm.setVar("SAVEDATA_TEXT", Str(prev+text))
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2682,7 +3681,7 @@ func (vm *VM) execBar(name, arg string) (execResult, error) {
 	if !vm.ui.SkipDisp {
 		vm.emitOutput(Output{Text: text, NewLine: name == "BARL"})
 	}
-	vm.globals["RESULTS"] = Str(text)
+	vm.setResultVar("RESULTS", Str(text))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2694,7 +3693,7 @@ func (vm *VM) execResetGlobal() (execResult, error) {
 	if err := vm.initDefines(); err != nil {
 		return execResult{}, err
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2703,10 +3702,22 @@ func (vm *VM) execResetData() (execResult, error) {
 	if err != nil {
 		return execResult{}, err
 	}
+	vm.resetCharacters()
+	return res, nil
+}
+
+// execResetChara empties the character roster and leaves globals alone.
+func (vm *VM) execResetChara() (execResult, error) {
+	vm.resetCharacters()
+	vm.setResultVar("RESULT", Int(1))
+	return execResult{kind: resultNone}, nil
+}
+
+func (vm *VM) resetCharacters() {
 	vm.characters = nil
 	vm.nextCharID = 0
+	vm.selectedChara = -1
 	vm.refreshCharacterGlobals()
-	return res, nil
 }
 
 func (vm *VM) execSwap(arg string) (execResult, error) {
@@ -2739,7 +3750,7 @@ func (vm *VM) execSwap(arg string) (execResult, error) {
 	if err := vm.setVarRef(b, av); err != nil {
 		return execResult{}, err
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2774,7 +3785,7 @@ func (vm *VM) execArrayShift(arg string) (execResult, error) {
 		return execResult{}, fmt.Errorf("ARRAYSHIFT target is not an array")
 	}
 	if len(arr.Dims) == 0 {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	}
 	n := int64(arr.Dims[0])
@@ -2783,7 +3794,7 @@ func (vm *VM) execArrayShift(arg string) (execResult, error) {
 		start = 0
 	}
 	if start >= n {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	}
 	if count < 1 {
@@ -2799,7 +3810,7 @@ func (vm *VM) execArrayShift(arg string) (execResult, error) {
 		}
 		_ = arr.Set([]int64{i}, val)
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2827,7 +3838,7 @@ func (vm *VM) execArrayRemove(arg string) (execResult, error) {
 		return execResult{}, fmt.Errorf("ARRAYREMOVE target is not an array")
 	}
 	_ = arr.Set([]int64{idxV.Int64()}, arr.defaultValue())
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -2875,10 +3886,51 @@ func (vm *VM) execArrayCopy(arg string) (execResult, error) {
 	for k, v := range src.Data {
 		dst.Data[k] = v
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
+	return execResult{kind: resultNone}, nil
+}
+
+// execCompactArray implements COMPACTARRAY arr: RESULT is the number of
+// default-valued elements released from the array's storage.
+func (vm *VM) execCompactArray(arg string) (execResult, error) {
+	if strings.TrimSpace(arg) == "" {
+		return execResult{}, fmt.Errorf("COMPACTARRAY requires variable")
+	}
+	ref, err := vm.parseVarRefRuntime(arg)
+	if err != nil {
+		return execResult{}, err
+	}
+	name := strings.ToUpper(ref.Name)
+	arr, ok := vm.lookupArray(name)
+	if !ok {
+		return execResult{}, fmt.Errorf("COMPACTARRAY target is not an array")
+	}
+	removed := 0
+	// Explicit empty names still override the CSV name, so keep them.
+	if !vm.isCharacterTextBase(name) {
+		removed = arr.compact()
+	}
+	vm.setResultVar("RESULT", Int(int64(removed)))
 	return execResult{kind: resultNone}, nil
 }
 
+// CompactAll runs COMPACTARRAY over every global and function-static array
+// and returns the number of elements released.
+func (vm *VM) CompactAll() int {
+	removed := 0
+	for name, arr := range vm.gArrays {
+		if !vm.isCharacterTextBase(name) {
+			removed += arr.compact()
+		}
+	}
+	for _, arrays := range vm.fArrays {
+		for _, arr := range arrays {
+			removed += arr.compact()
+		}
+	}
+	return removed
+}
+
 func (vm *VM) execArraySort(arg string) (execResult, error) {
 	parts := splitTopLevelRuntime(arg, ',')
 	if len(parts) == 0 {
@@ -2896,12 +3948,12 @@ func (vm *VM) execArraySort(arg string) (execResult, error) {
 		return execResult{}, fmt.Errorf("ARRAYSORT target is not an array")
 	}
 	if len(arr.Dims) == 0 {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	}
 	n := arr.Dims[0]
 	if n <= 1 {
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 		return execResult{kind: resultNone}, nil
 	}
 	vals := make([]Value, n)
@@ -2939,27 +3991,54 @@ func (vm *VM) execArraySort(arg string) (execResult, error) {
 	for i := 0; i < n; i++ {
 		_ = arr.Set([]int64{int64(i)}, vals[i])
 	}
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
 func (vm *VM) execDrawLine(name, arg string) (execResult, error) {
 	text := strings.Repeat("-", 40)
-	if strings.Contains(name, "FORM") {
-		v, err := vm.evalPrintForm(arg)
-		if err == nil && strings.TrimSpace(v) != "" {
-			text = v
+	pattern := ""
+	switch name {
+	case "DRAWLINEFORM":
+		if v, err := vm.evalPrintForm(arg); err == nil {
+			pattern = v
 		}
-	} else if strings.TrimSpace(arg) != "" {
-		v, err := vm.evalLooseExpr(arg)
-		if err == nil && strings.TrimSpace(v.String()) != "" {
-			text = v.String()
+	case "CUSTOMDRAWLINE":
+		if v, err := vm.evalLooseExpr(arg); err == nil {
+			pattern = v.String()
+		}
+	default:
+		if strings.TrimSpace(arg) != "" {
+			v, err := vm.evalLooseExpr(arg)
+			if err == nil && strings.TrimSpace(v.String()) != "" {
+				text = v.String()
+			}
 		}
 	}
+	if strings.TrimSpace(pattern) != "" {
+		text = repeatToColumns(pattern, int(vm.ui.PrintCPL)*vm.ui.PrintCLength)
+	}
 	vm.emitOutput(Output{Text: text, NewLine: true})
 	return execResult{kind: resultNone}, nil
 }
 
+// repeatToColumns repeats pattern until it fills width display columns,
+// cutting the final repeat at the last rune that still fits.
+func repeatToColumns(pattern string, width int) string {
+	var b strings.Builder
+	col := 0
+	for {
+		for _, r := range pattern {
+			w := int(sjisByteWidth(r))
+			if col+w > width {
+				return b.String()
+			}
+			b.WriteRune(r)
+			col += w
+		}
+	}
+}
+
 func (vm *VM) execClearLine(arg string) (execResult, error) {
 	n := int64(1)
 	if strings.TrimSpace(arg) != "" {
@@ -2977,13 +4056,13 @@ func (vm *VM) execReuseLastLine() (execResult, error) {
 		return execResult{kind: resultNone}, nil
 	}
 	last := vm.outputs[len(vm.outputs)-1]
-	vm.emitOutput(last)
+	vm.emitRawOutput(last)
 	return execResult{kind: resultNone}, nil
 }
 
 func (vm *VM) execAlignment(arg string) (execResult, error) {
 	if strings.TrimSpace(arg) == "" {
-		vm.globals["RESULT"] = Str(vm.ui.Align)
+		vm.setResultVar("RESULT", Str(vm.ui.Align))
 		return execResult{kind: resultNone}, nil
 	}
 	v, err := vm.evalLooseExpr(arg)
@@ -2991,7 +4070,7 @@ func (vm *VM) execAlignment(arg string) (execResult, error) {
 		return execResult{}, err
 	}
 	vm.ui.Align = normalizeAlign(v.String())
-	vm.globals["RESULT"] = Str(vm.ui.Align)
+	vm.setResultVar("RESULT", Str(vm.ui.Align))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -3019,9 +4098,9 @@ func (vm *VM) execSkipDisp(arg string) (execResult, error) {
 		vm.ui.SkipDisp = v.Int64() != 0
 	}
 	if vm.ui.SkipDisp {
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 	} else {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 	}
 	return execResult{kind: resultNone}, nil
 }
@@ -3032,7 +4111,7 @@ func (vm *VM) execSetColor(arg string) (execResult, error) {
 		return execResult{}, err
 	}
 	vm.ui.Color = strings.TrimSpace(v.String())
-	vm.globals["RESULT"] = Str(vm.ui.Color)
+	vm.setResultVar("RESULT", Str(vm.ui.Color))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -3042,7 +4121,27 @@ func (vm *VM) execSetBgColor(arg string) (execResult, error) {
 		return execResult{}, err
 	}
 	vm.ui.BgColor = strings.TrimSpace(v.String())
-	vm.globals["RESULT"] = Str(vm.ui.BgColor)
+	vm.setResultVar("RESULT", Str(vm.ui.BgColor))
+	return execResult{kind: resultNone}, nil
+}
+
+// execSetColorByName resolves a registered or built-in color name to its
+// RGB hex form; unknown names are stored verbatim as before.
+func (vm *VM) execSetColorByName(arg string, bg bool) (execResult, error) {
+	v, err := vm.evalLooseExpr(arg)
+	if err != nil {
+		return execResult{}, err
+	}
+	color := strings.TrimSpace(v.String())
+	if rgb, ok := vm.lookupNamedColor(color); ok {
+		color = fmt.Sprintf("%06X", rgb)
+	}
+	if bg {
+		vm.ui.BgColor = color
+	} else {
+		vm.ui.Color = color
+	}
+	vm.setResultVar("RESULT", Str(color))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -3054,11 +4153,15 @@ func (vm *VM) execFontStyle(arg string) (execResult, error) {
 	style := v.Int64()
 	vm.ui.Bold = (style & 1) != 0
 	vm.ui.Italic = (style & 2) != 0
-	vm.globals["RESULT"] = Int(style)
+	vm.setResultVar("RESULT", Int(style))
 	return execResult{kind: resultNone}, nil
 }
 
 func (vm *VM) execPrintCPerLine(arg string) (execResult, error) {
+	if arg == "" {
+		vm.setResultVar("RESULT", Int(vm.ui.PrintCPL))
+		return execResult{kind: resultNone}, nil
+	}
 	v, err := vm.evalLooseExpr(arg)
 	if err != nil {
 		return execResult{}, err
@@ -3068,11 +4171,180 @@ func (vm *VM) execPrintCPerLine(arg string) (execResult, error) {
 		n = 1
 	}
 	vm.ui.PrintCPL = n
-	vm.globals["RESULT"] = Int(n)
+	vm.setResultVar("RESULT", Int(n))
+	return execResult{kind: resultNone}, nil
+}
+
+func (vm *VM) execPrintImg(arg string) (execResult, error) {
+	v, err := vm.evalLooseExpr(arg)
+	ref := ""
+	if err == nil {
+		ref = v.String()
+	} else {
+		ref = strings.Trim(strings.TrimSpace(decodeCommandCharSeq(arg)), "\"")
+	}
+	if strings.TrimSpace(ref) == "" {
+		return execResult{}, fmt.Errorf("PRINTIMG requires an image path or id")
+	}
+	vm.emitRawOutput(Output{Image: ref})
+	return execResult{kind: resultNone}, nil
+}
+
+// randStream is one RAND generator. Its source keeps the whole generator
+// state in plain fields, so QUICKSAVE can capture it without drawing.
+type randStream struct {
+	*rand.Rand
+	src *stateSource
+}
+
+func newRandStream(seed int64) *randStream {
+	src := &stateSource{}
+	src.Seed(seed)
+	return &randStream{Rand: rand.New(src), src: src}
+}
+
+// randStreamState is the saved form of a randStream.
+type randStreamState struct {
+	Vec    []byte `json:"vec"`
+	Tap    int    `json:"tap"`
+	Feed   int    `json:"feed"`
+	Primed int    `json:"primed,omitempty"`
+}
+
+func (r *randStream) state() randStreamState {
+	vec := make([]byte, 0, 8*alfgLen)
+	for _, v := range r.src.vec {
+		vec = binary.LittleEndian.AppendUint64(vec, uint64(v))
+	}
+	return randStreamState{Vec: vec, Tap: r.src.tap, Feed: r.src.feed, Primed: r.src.primed}
+}
+
+func (r *randStream) setState(st randStreamState) error {
+	if len(st.Vec) != 8*alfgLen || st.Tap < 0 || st.Tap >= alfgLen || st.Feed < 0 || st.Feed >= alfgLen || st.Primed < 0 || st.Primed > alfgLen {
+		return fmt.Errorf("invalid random generator state")
+	}
+	for i := range r.src.vec {
+		r.src.vec[i] = int64(binary.LittleEndian.Uint64(st.Vec[8*i:]))
+	}
+	r.src.tap, r.src.feed, r.src.primed = st.Tap, st.Feed, st.Primed
+	return nil
+}
+
+// alfgLen and alfgTap are the lags of math/rand's additive lagged
+// Fibonacci generator.
+const (
+	alfgLen = 607
+	alfgTap = 273
+)
+
+// stateSource yields exactly the values of math/rand's default source for
+// the same seed. That source hides its state, so Seed runs it for one full
+// lag instead: those outputs are precisely the state vector, and they are
+// handed out again (primed) before the generator steps on its own.
+type stateSource struct {
+	vec       [alfgLen]int64
+	tap, feed int
+	primed    int
+}
+
+func (s *stateSource) Seed(seed int64) {
+	src := rand.NewSource(seed).(rand.Source64)
+	s.tap, s.feed, s.primed = 0, alfgLen-alfgTap, alfgLen
+	for k := 1; k <= alfgLen; k++ {
+		s.vec[(s.feed-k+alfgLen)%alfgLen] = int64(src.Uint64())
+	}
+}
+
+func (s *stateSource) Uint64() uint64 {
+	if s.primed > 0 {
+		k := alfgLen - s.primed + 1
+		s.primed--
+		return uint64(s.vec[(s.feed-k+alfgLen)%alfgLen])
+	}
+	s.tap--
+	if s.tap < 0 {
+		s.tap += alfgLen
+	}
+	s.feed--
+	if s.feed < 0 {
+		s.feed += alfgLen
+	}
+	x := s.vec[s.feed] + s.vec[s.tap]
+	s.vec[s.feed] = x
+	return uint64(x)
+}
+
+func (s *stateSource) Int63() int64 {
+	return int64(s.Uint64() & (1<<63 - 1))
+}
+
+// defaultRandStream names the generator scripts use until RANDSTREAM.
+const defaultRandStream = "DEFAULT"
+
+// selectRandStream makes the named generator current for RAND, INITRAND,
+// RANDOMIZE and DUMPRAND, creating it on first use. An empty name returns
+// to the default stream.
+func (vm *VM) selectRandStream(arg string) {
+	name := strings.TrimSpace(arg)
+	if uq, ok := tryUnquoteCommandString(name); ok {
+		name = strings.TrimSpace(uq)
+	}
+	name = strings.ToUpper(name)
+	if name == "" {
+		name = defaultRandStream
+	}
+	r, ok := vm.rngStreams[name]
+	if !ok {
+		r = newRandStream(time.Now().UnixNano())
+		vm.rngStreams[name] = r
+	}
+	vm.rng = r
+}
+
+// randN draws RAND:n from the current stream.
+func (vm *VM) randN(n int64) int64 {
+	return vm.logRand(RandDraw, vm.rng.Int63n(n))
+}
+
+// randIntn draws the entry PRINTDATA and its relatives pick, with Intn so
+// a seed picks the same entry it did before draws were logged.
+func (vm *VM) randIntn(n int) int {
+	return int(vm.logRand(RandDraw, int64(vm.rng.Intn(n))))
+}
+
+// randInt63 draws a raw non-negative value from the current stream.
+func (vm *VM) randInt63() int64 {
+	return vm.logRand(RandDraw, vm.rng.Int63())
+}
+
+// seedRand reseeds the current stream.
+func (vm *VM) seedRand(seed int64) {
+	vm.rng.Seed(seed)
+	vm.logRand(RandSeed, seed)
+}
+
+func (vm *VM) logRand(kind RandEventKind, v int64) int64 {
+	if vm.randLogging {
+		vm.randLog = append(vm.randLog, RandEvent{Kind: kind, Value: v})
+	}
+	return v
+}
+
+func (vm *VM) execTabWidth(arg string) (execResult, error) {
+	v, err := vm.evalLooseExpr(arg)
+	if err != nil {
+		return execResult{}, err
+	}
+	n := v.Int64()
+	if n < 0 {
+		n = 0
+	}
+	vm.ui.TabWidth = int(n)
+	vm.setResultVar("RESULT", Int(n))
 	return execResult{kind: resultNone}, nil
 }
 
-func (vm *VM) execAddChara(arg string) (execResult, error) {
+func (vm *VM) execAddChara(name, arg string) (execResult, error) {
 	id := int64(-1)
 	if strings.TrimSpace(arg) != "" {
 		v, err := vm.evalLooseExpr(arg)
@@ -3080,8 +4352,8 @@ func (vm *VM) execAddChara(arg string) (execResult, error) {
 			id = v.Int64()
 		}
 	}
-	idx := vm.addCharacter(id)
-	vm.globals["RESULT"] = Int(idx)
+	idx := vm.addCharacter(id, name == "ADDVOIDCHARA")
+	vm.setResultVar("RESULT", Int(idx))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -3091,16 +4363,16 @@ func (vm *VM) execDelChara(arg string) (execResult, error) {
 		return execResult{}, err
 	}
 	if vm.deleteCharacterAt(v.Int64()) {
-		vm.globals["RESULT"] = Int(1)
+		vm.setResultVar("RESULT", Int(1))
 	} else {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 	}
 	return execResult{kind: resultNone}, nil
 }
 
 func (vm *VM) execGetChara(arg string) (execResult, error) {
 	if strings.TrimSpace(arg) == "" {
-		vm.globals["RESULT"] = Int(int64(len(vm.characters)))
+		vm.setResultVar("RESULT", Int(int64(len(vm.characters))))
 		return execResult{kind: resultNone}, nil
 	}
 	v, err := vm.evalLooseExpr(arg)
@@ -3109,9 +4381,9 @@ func (vm *VM) execGetChara(arg string) (execResult, error) {
 	}
 	i := v.Int64()
 	if i < 0 || i >= int64(len(vm.characters)) {
-		vm.globals["RESULT"] = Int(-1)
+		vm.setResultVar("RESULT", Int(-1))
 	} else {
-		vm.globals["RESULT"] = Int(vm.characters[i].ID)
+		vm.setResultVar("RESULT", Int(vm.characters[i].ID))
 	}
 	return execResult{kind: resultNone}, nil
 }
@@ -3125,19 +4397,19 @@ func (vm *VM) execFindChara(arg string, reverse bool) (execResult, error) {
 	if reverse {
 		for i := len(vm.characters) - 1; i >= 0; i-- {
 			if vm.characters[i].ID == id {
-				vm.globals["RESULT"] = Int(int64(i))
+				vm.setResultVar("RESULT", Int(int64(i)))
 				return execResult{kind: resultNone}, nil
 			}
 		}
 	} else {
 		for i := 0; i < len(vm.characters); i++ {
 			if vm.characters[i].ID == id {
-				vm.globals["RESULT"] = Int(int64(i))
+				vm.setResultVar("RESULT", Int(int64(i)))
 				return execResult{kind: resultNone}, nil
 			}
 		}
 	}
-	vm.globals["RESULT"] = Int(-1)
+	vm.setResultVar("RESULT", Int(-1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -3159,11 +4431,17 @@ func (vm *VM) execSwapChara(arg string) (execResult, error) {
 	}
 	ai, bi := int(a.Int64()), int(b.Int64())
 	if ai < 0 || bi < 0 || ai >= len(vm.characters) || bi >= len(vm.characters) {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	}
 	vm.characters[ai], vm.characters[bi] = vm.characters[bi], vm.characters[ai]
-	vm.globals["RESULT"] = Int(1)
+	switch vm.selectedChara {
+	case ai:
+		vm.selectedChara = bi
+	case bi:
+		vm.selectedChara = ai
+	}
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -3181,12 +4459,13 @@ func (vm *VM) execCopyChara(arg string, add bool) (execResult, error) {
 	}
 	src := int(srcV.Int64())
 	if src < 0 || src >= len(vm.characters) {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	}
 	if add || len(parts) < 2 {
-		idx := vm.addCharacter(vm.characters[src].ID)
-		vm.globals["RESULT"] = Int(idx)
+		idx := vm.addCharacter(vm.characters[src].ID, true)
+		vm.copyCharacterData(src, int(idx))
+		vm.setResultVar("RESULT", Int(idx))
 		return execResult{kind: resultNone}, nil
 	}
 	dstV, err := vm.evalLooseExpr(parts[1])
@@ -3195,11 +4474,11 @@ func (vm *VM) execCopyChara(arg string, add bool) (execResult, error) {
 	}
 	dst := int(dstV.Int64())
 	if dst < 0 || dst >= len(vm.characters) {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	}
-	vm.characters[dst].ID = vm.characters[src].ID
-	vm.globals["RESULT"] = Int(1)
+	vm.copyCharacterData(src, dst)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
@@ -3211,16 +4490,19 @@ func (vm *VM) execPickupChara(arg string) (execResult, error) {
 	id := v.Int64()
 	for i := range vm.characters {
 		if vm.characters[i].ID == id {
-			vm.globals["RESULT"] = Int(int64(i))
+			vm.setResultVar("RESULT", Int(int64(i)))
 			return execResult{kind: resultNone}, nil
 		}
 	}
-	vm.globals["RESULT"] = Int(-1)
+	vm.setResultVar("RESULT", Int(-1))
 	return execResult{kind: resultNone}, nil
 }
 
 func (vm *VM) lookupArray(name string) (*ArrayVar, bool) {
 	name = strings.ToUpper(name)
+	if isResultLikeName(name) {
+		return vm.resultArray(name), true
+	}
 	if fr := vm.currentFrame(); fr != nil {
 		if arr, ok := fr.lArrays[name]; ok {
 			return arr, true
@@ -3262,14 +4544,58 @@ func (vm *VM) execRefBinding(name, arg string) (execResult, error) {
 		}
 	}
 	if !vm.isRefDeclared(dst.Name) {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return execResult{kind: resultNone}, nil
 	}
+	src, err = vm.freezeVarRefIndex(src)
+	if err != nil {
+		return execResult{}, err
+	}
 	vm.setRefBinding(strings.ToUpper(dst.Name), src)
-	vm.globals["RESULT"] = Int(1)
+	vm.setResultVar("RESULT", Int(1))
 	return execResult{kind: resultNone}, nil
 }
 
+// freezeVarRefIndex evaluates the indices of an element reference once so a
+// REF bound to ARR:I keeps aliasing the same element after I changes. A
+// string index that names no CSV entry stays a string key where the array
+// accepts one, like FLAG:"quest", and is an error otherwise.
+func (vm *VM) freezeVarRefIndex(ref ast.VarRef) (ast.VarRef, error) {
+	if len(ref.Index) == 0 {
+		return ref, nil
+	}
+	name := strings.ToUpper(ref.Name)
+	if _, ok := vm.namedIndexKey(name, ref.Index); ok {
+		return ref, nil
+	}
+	csvBase := csvBaseFromVarName(name)
+	frozen := ast.VarRef{Name: ref.Name, Index: make([]ast.Expr, len(ref.Index))}
+	for i, expr := range ref.Index {
+		if mapped, ok := vm.resolveNamedCSVIndex(name, expr); ok {
+			frozen.Index[i] = ast.IntLit{Value: mapped}
+			continue
+		}
+		v, err := vm.evalExpr(expr)
+		if err != nil {
+			return ast.VarRef{}, err
+		}
+		if mapped, ok := vm.resolveNamedCSVIndexValue(csvBase, v); ok {
+			frozen.Index[i] = ast.IntLit{Value: mapped}
+			continue
+		}
+		if key := strings.TrimSpace(v.String()); v.Kind() == StringKind && key != "" && !isNumericLike(key) {
+			lit := []ast.Expr{ast.StringLit{Value: v.String()}}
+			if _, ok := vm.namedIndexKey(name, lit); ok && len(ref.Index) == 1 {
+				frozen.Index = lit
+				return frozen, nil
+			}
+			return ast.VarRef{}, fmt.Errorf("%s: no CSV entry named %q", ref.Name, v.String())
+		}
+		frozen.Index[i] = ast.IntLit{Value: v.Int64()}
+	}
+	return frozen, nil
+}
+
 func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 	args, err := vm.evalCommandArgs(arg)
 	if err != nil {
@@ -3493,6 +4819,9 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		if len(args) < 2 {
 			return Int(0), true, nil
 		}
+		if isFloatOperation(args[0], args[1]) {
+			return Float(math.Pow(args[0].Float64(), args[1].Float64())), true, nil
+		}
 		base := args[0].Int64()
 		exp := args[1].Int64()
 		if exp < 0 {
@@ -3526,6 +4855,9 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		if len(args) < 1 {
 			return Int(0), true, nil
 		}
+		if args[0].Kind() == FloatKind {
+			return Float(math.Sqrt(args[0].Float64())), true, nil
+		}
 		v := args[0].Int64()
 		if v < 0 {
 			return Int(0), true, nil
@@ -3535,12 +4867,18 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		if len(args) < 1 {
 			return Int(0), true, nil
 		}
+		if args[0].Kind() == FloatKind {
+			return Float(math.Cbrt(args[0].Float64())), true, nil
+		}
 		v := args[0].Int64()
 		return Int(int64(math.Cbrt(float64(v)))), true, nil
 	case "LOG":
 		if len(args) < 1 {
 			return Int(0), true, nil
 		}
+		if args[0].Kind() == FloatKind {
+			return Float(math.Log(args[0].Float64())), true, nil
+		}
 		v := args[0].Int64()
 		if v <= 0 {
 			return Int(0), true, nil
@@ -3550,6 +4888,9 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		if len(args) < 1 {
 			return Int(0), true, nil
 		}
+		if args[0].Kind() == FloatKind {
+			return Float(math.Log10(args[0].Float64())), true, nil
+		}
 		v := args[0].Int64()
 		if v <= 0 {
 			return Int(0), true, nil
@@ -3599,7 +4940,7 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		if n <= 0 {
 			return Int(0), true, nil
 		}
-		return Int(vm.rng.Int63n(n)), true, nil
+		return Int(vm.randN(n)), true, nil
 	case "STRLEN", "STRLENU", "STRLENS", "STRLENSU":
 		if len(args) < 1 {
 			return Int(0), true, nil
@@ -3620,6 +4961,10 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 			start = args[2].Int64()
 		}
 		return Int(strFindRuneIndex(args[0].String(), args[1].String(), start)), true, nil
+	case "LASTINPUT":
+		// The raw text of the most recently answered input request, kept
+		// after RESULT/RESULTS have been overwritten.
+		return Str(vm.input.LastValue), true, nil
 	case "LINEISEMPTY":
 		if len(vm.outputs) == 0 {
 			return Int(1), true, nil
@@ -3642,14 +4987,7 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		if len(args) < 1 {
 			return Int(0), true, nil
 		}
-		name := strings.ToLower(strings.TrimSpace(args[0].String()))
-		colorMap := map[string]int64{
-			"black": 0x000000, "white": 0xFFFFFF, "red": 0xFF0000, "green": 0x00FF00,
-			"blue": 0x0000FF, "yellow": 0xFFFF00, "cyan": 0x00FFFF, "magenta": 0xFF00FF,
-			"gray": 0x808080, "grey": 0x808080, "orange": 0xFFA500, "purple": 0x800080,
-			"pink": 0xFFC0CB, "brown": 0xA52A2A,
-		}
-		if c, ok := colorMap[name]; ok {
+		if c, ok := vm.lookupNamedColor(args[0].String()); ok {
 			return Int(c), true, nil
 		}
 		return Int(0), true, nil
@@ -3661,7 +4999,16 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		g := args[1].Int64() & 0xFF
 		b := args[2].Int64() & 0xFF
 		return Int((r << 16) | (g << 8) | b), true, nil
-	case "SUBSTRING", "SUBSTRINGU":
+	case "SUBSTRING", "BYTESUBSTRING":
+		if len(args) < 2 {
+			return Str(""), true, nil
+		}
+		length := int64(-1)
+		if len(args) >= 3 {
+			length = args[2].Int64()
+		}
+		return Str(substringBytes(args[0].String(), args[1].Int64(), length)), true, nil
+	case "SUBSTRINGU":
 		if len(args) < 2 {
 			return Str(""), true, nil
 		}
@@ -3686,6 +5033,28 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 			}
 		}
 		return Str(string(src[start:end])), true, nil
+	case "GETWINDOWTITLE":
+		return Str(vm.windowTitle), true, nil
+	case "SYNCNO":
+		vm.syncNoArray()
+		return Int(int64(len(vm.characters))), true, nil
+	case "ARGC":
+		if fr := vm.currentFrame(); fr != nil {
+			return Int(int64(fr.argc)), true, nil
+		}
+		return Int(0), true, nil
+	case "GETCHARAINDEX":
+		// Resolves a character ID to its current roster slot, which changes
+		// under SWAPCHARA/SORTCHARA/DELCHARA while the ID does not.
+		if len(args) < 1 {
+			return Int(-1), true, nil
+		}
+		return Int(vm.characterIndexByID(args[0].Int64())), true, nil
+	case "SYSINFO":
+		if len(args) < 1 {
+			return Str(""), true, nil
+		}
+		return Str(vm.sysInfo[strings.ToUpper(strings.TrimSpace(args[0].String()))]), true, nil
 	case "TOINT":
 		if len(args) < 1 {
 			return Int(0), true, nil
@@ -3696,6 +5065,21 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 			return Str(""), true, nil
 		}
 		return Str(args[0].String()), true, nil
+	case "SIGNEDSTR":
+		if len(args) < 1 {
+			return Str(""), true, nil
+		}
+		if args[0].Kind() == FloatKind {
+			if args[0].Float64() > 0 {
+				return Str("+" + args[0].String()), true, nil
+			}
+			return Str(args[0].String()), true, nil
+		}
+		n := args[0].Int64()
+		if n > 0 {
+			return Str("+" + strconv.FormatInt(n, 10)), true, nil
+		}
+		return Str(strconv.FormatInt(n, 10)), true, nil
 	case "TOUPPER":
 		if len(args) < 1 {
 			return Str(""), true, nil
@@ -3791,7 +5175,7 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		if len(args) < 1 {
 			return Str("0"), true, nil
 		}
-		return Str(strconv.FormatInt(args[0].Int64(), 10)), true, nil
+		return Str(vm.formatNumber(args[0])), true, nil
 	case "EXISTCSV":
 		if len(args) < 1 {
 			return Int(0), true, nil
@@ -3848,9 +5232,19 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 			return Int(0), true, nil
 		}
 		v := args[0].Int64()
-		levels := []int64{0, 100, 500, 3000, 10000, 30000, 60000, 100000, 150000, 250000}
-		if name == "GETEXPLV" {
-			levels = []int64{0, 1, 4, 20, 50, 200}
+		levels, _ := vm.levelTable(strings.TrimPrefix(name, "GET"))
+		maxLv := int64(-1)
+		if len(args) >= 2 {
+			// A second argument naming a table selects it; otherwise it is
+			// Emuera's numeric max level.
+			custom, isTable := vm.levelTable(args[1].String())
+			if isTable && args[1].Kind() == StringKind {
+				levels = custom
+			} else if n, err := strconv.ParseInt(strings.TrimSpace(args[1].String()), 10, 64); err == nil {
+				maxLv = n
+			} else {
+				return Value{}, true, fmt.Errorf("%s: unknown level table %q", name, args[1].String())
+			}
 		}
 		lv := int64(0)
 		for i, th := range levels {
@@ -3858,6 +5252,9 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 				lv = int64(i)
 			}
 		}
+		if maxLv >= 0 && lv > maxLv {
+			lv = maxLv
+		}
 		return Int(lv), true, nil
 	case "HTML_STRINGLEN":
 		if len(args) < 1 {
@@ -3906,11 +5303,16 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 			return Int(1), true, nil
 		}
 		return Int(0), true, nil
+	case "VARKIND":
+		if len(args) < 1 {
+			return Int(0), true, nil
+		}
+		return Int(vm.varKind(args[0].String())), true, nil
 	case "GETVAR":
 		if len(args) < 1 {
 			return Int(0), true, nil
 		}
-		name := strings.ToUpper(strings.TrimSpace(args[0].String()))
+		name := args[0].String()
 		v, err := vm.resolveVarByName(name, args[1:])
 		if err != nil {
 			return Int(0), true, nil
@@ -3920,7 +5322,7 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		if len(args) < 1 {
 			return Str(""), true, nil
 		}
-		name := strings.ToUpper(strings.TrimSpace(args[0].String()))
+		name := args[0].String()
 		v, err := vm.resolveVarByName(name, args[1:])
 		if err != nil {
 			return Str(""), true, nil
@@ -3930,7 +5332,7 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		if len(args) < 2 {
 			return Value{}, true, fmt.Errorf("SETVAR requires variable name and value")
 		}
-		name := strings.ToUpper(strings.TrimSpace(args[0].String()))
+		name := args[0].String()
 		if len(args) >= 3 {
 			indices := args[1 : len(args)-1]
 			value := args[len(args)-1]
@@ -3953,6 +5355,20 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 			return Int(1), true, nil
 		}
 		return Int(0), true, nil
+	case "STATEHASH":
+		return Str(vm.StateHash()), true, nil
+	case "REGEXPREPLACE":
+		if len(args) < 3 {
+			return Value{}, true, fmt.Errorf("REGEXPREPLACE requires source, pattern and replacement")
+		}
+		re, err := regexp.Compile(args[1].String())
+		if err != nil {
+			return Value{}, true, fmt.Errorf("REGEXPREPLACE invalid pattern: %w", err)
+		}
+		source := args[0].String()
+		matches := re.FindAllStringIndex(source, -1)
+		vm.setResultVar("RESULTS", Str(re.ReplaceAllString(source, args[2].String())))
+		return Int(int64(len(matches))), true, nil
 	case "ENUMFUNCBEGINSWITH", "ENUMFUNCENDSWITH", "ENUMFUNCWITH":
 		return vm.enumFunctions(args, name), true, nil
 	case "ENUMVARBEGINSWITH", "ENUMVARENDSWITH", "ENUMVARWITH":
@@ -3978,6 +5394,12 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		return vm.csvStrData(args, "MASTERNAME"), true, nil
 	case "CSVCSTR":
 		return vm.csvCStrData(args), true, nil
+	case "CSVTRAIN":
+		if len(args) < 1 {
+			return Str(""), true, nil
+		}
+		name, _ := vm.csv.Name("TRAIN", args[0].Int64())
+		return Str(name), true, nil
 	case "CSVBASE":
 		return vm.csvIntData(args, "BASE"), true, nil
 	case "CSVABL":
@@ -3998,6 +5420,49 @@ func (vm *VM) execMethodLike(name, arg string) (Value, bool, error) {
 		return vm.csvIntData(args, "JUEL"), true, nil
 	case "GETCHARA":
 		return vm.csvGetChara(args, false), true, nil
+	case "CHARANUM":
+		return Int(int64(len(vm.characters))), true, nil
+	case "CHARAEXISTS":
+		if len(args) == 0 {
+			return Value{}, true, fmt.Errorf("CHARAEXISTS requires an index")
+		}
+		if i := args[0].Int64(); i >= 0 && i < int64(len(vm.characters)) {
+			return Int(1), true, nil
+		}
+		return Int(0), true, nil
+	case "GETCHARADATA":
+		if len(args) < 2 {
+			return Value{}, true, fmt.Errorf("GETCHARADATA requires an index and a variable name")
+		}
+		i := args[0].Int64()
+		if i < 0 || i >= int64(len(vm.characters)) {
+			return Int(0), true, nil
+		}
+		vars := vm.characters[i].Vars
+		if key, ok := charaDataKey(vars, args[1].String()); ok {
+			return vars[key], true, nil
+		}
+		return Int(0), true, nil
+	case "SETCHARADATA":
+		if len(args) < 3 {
+			return Value{}, true, fmt.Errorf("SETCHARADATA requires an index, a variable name and a value")
+		}
+		i := args[0].Int64()
+		key := strings.TrimSpace(args[1].String())
+		if i < 0 || i >= int64(len(vm.characters)) || key == "" {
+			return Int(0), true, nil
+		}
+		ch := &vm.characters[i]
+		if ch.Vars == nil {
+			ch.Vars = map[string]Value{}
+		}
+		if existing, ok := charaDataKey(ch.Vars, key); ok {
+			key = existing
+		} else {
+			key = strings.ToUpper(key)
+		}
+		ch.Vars[key] = vm.charaDataValue(ch.Vars, key, args[2])
+		return Int(1), true, nil
 	case "GETSPCHARA":
 		return vm.csvGetChara(args, true), true, nil
 	case "GETCONFIG":
@@ -4770,30 +6235,129 @@ func csvNameVarTargetBase(name string) (string, bool) {
 	}
 }
 
+// sjisByteWidth reports the Shift-JIS byte width Emuera uses for the non-U
+// string functions: ASCII and half-width katakana are one byte, the rest two.
+func sjisByteWidth(r rune) int64 {
+	if r < 0x80 || (r >= 0xFF61 && r <= 0xFF9F) {
+		return 1
+	}
+	return 2
+}
+
+// substringBytes slices s by Shift-JIS byte offsets. Characters straddling
+// either boundary are dropped; a negative length means "to the end".
+func substringBytes(s string, start, length int64) string {
+	if start < 0 {
+		start = 0
+	}
+	var b strings.Builder
+	pos := int64(0)
+	for _, r := range s {
+		w := sjisByteWidth(r)
+		if pos >= start && (length < 0 || pos+w <= start+length) {
+			b.WriteRune(r)
+		}
+		pos += w
+		if length >= 0 && pos >= start+length {
+			break
+		}
+	}
+	return b.String()
+}
+
+// halfKana and fullKana list the half-width katakana block (U+FF61–U+FF9F)
+// and the full-width characters each position maps to.
+const (
+	halfKana = "｡｢｣､･ｦｧｨｩｪｫｬｭｮｯｰｱｲｳｴｵｶｷｸｹｺｻｼｽｾｿﾀﾁﾂﾃﾄﾅﾆﾇﾈﾉﾊﾋﾌﾍﾎﾏﾐﾑﾒﾓﾔﾕﾖﾗﾘﾙﾚﾛﾜﾝﾞﾟ"
+	fullKana = "。「」、・ヲァィゥェォャュョッーアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワン゛゜"
+)
+
+var (
+	halfToFullKana = map[rune]rune{}
+	fullToHalfKana = map[rune]rune{}
+)
+
+func init() {
+	half, full := []rune(halfKana), []rune(fullKana)
+	for i := range half {
+		halfToFullKana[half[i]] = full[i]
+		fullToHalfKana[full[i]] = half[i]
+	}
+}
+
+// voicedKana returns the full-width kana produced by combining base with a
+// half-width dakuten (ﾞ) or handakuten (ﾟ) mark.
+func voicedKana(base, mark rune) (rune, bool) {
+	switch mark {
+	case 'ﾞ':
+		if base == 'ウ' {
+			return 'ヴ', true
+		}
+		if strings.ContainsRune("カキクケコサシスセソタチツテトハヒフヘホ", base) {
+			return base + 1, true
+		}
+	case 'ﾟ':
+		if strings.ContainsRune("ハヒフヘホ", base) {
+			return base + 2, true
+		}
+	}
+	return 0, false
+}
+
 func toHalfWidth(s string) string {
-	runes := []rune(s)
-	for i, r := range runes {
+	var b strings.Builder
+	for _, r := range s {
 		switch {
 		case r == '　':
-			runes[i] = ' '
+			b.WriteRune(' ')
 		case r >= '！' && r <= '～':
-			runes[i] = r - 0xFEE0
+			b.WriteRune(r - 0xFEE0)
+		case r == 'ヴ':
+			b.WriteString("ｳﾞ")
+		case strings.ContainsRune("ガギグゲゴザジズゼゾダヂヅデドバビブベボ", r):
+			b.WriteRune(fullToHalfKana[r-1])
+			b.WriteRune('ﾞ')
+		case strings.ContainsRune("パピプペポ", r):
+			b.WriteRune(fullToHalfKana[r-2])
+			b.WriteRune('ﾟ')
+		default:
+			if h, ok := fullToHalfKana[r]; ok {
+				b.WriteRune(h)
+			} else {
+				b.WriteRune(r)
+			}
 		}
 	}
-	return string(runes)
+	return b.String()
 }
 
 func toFullWidth(s string) string {
 	runes := []rune(s)
-	for i, r := range runes {
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
 		switch {
 		case r == ' ':
-			runes[i] = '　'
+			b.WriteRune('　')
 		case r >= '!' && r <= '~':
-			runes[i] = r + 0xFEE0
+			b.WriteRune(r + 0xFEE0)
+		default:
+			f, ok := halfToFullKana[r]
+			if !ok {
+				b.WriteRune(r)
+				continue
+			}
+			if i+1 < len(runes) {
+				if v, ok := voicedKana(f, runes[i+1]); ok {
+					b.WriteRune(v)
+					i++
+					continue
+				}
+			}
+			b.WriteRune(f)
 		}
 	}
-	return string(runes)
+	return b.String()
 }
 
 func strFindRuneIndex(src, needle string, start int64) int64 {
@@ -5388,6 +6952,42 @@ func isColumnPrint(name string) bool {
 	return strings.HasSuffix(name, "C")
 }
 
+// emitColumnCell pads one PRINTC/PRINTLC item to PrintCLength and ends
+// the row once PrintCPL cells have been printed. The FORM and BUTTON
+// variants share the same row. ...LC cells are always left-aligned; ...C
+// cells follow ALIGNMENT.
+func (vm *VM) emitColumnCell(name string, cell Output) {
+	align := vm.ui.Align
+	if strings.HasSuffix(strings.TrimSuffix(name, "W"), "LC") {
+		align = "LEFT"
+	}
+	cell.Text = formatPrintField(cell.Text, vm.ui.PrintCLength, align)
+	// Keep at least one visible separator between column cells even when
+	// content length reaches/exceeds the configured column width.
+	if !strings.HasSuffix(cell.Text, " ") {
+		cell.Text += " "
+	}
+	vm.printCCounter++
+	if vm.printCCounter >= int(vm.ui.PrintCPL) {
+		cell.NewLine = true
+		vm.printCCounter = 0
+		vm.writeOutput(cell)
+		return
+	}
+	cell.NewLine = false
+	vm.writeOutput(cell)
+}
+
+// endColumnRow terminates a partially filled PRINTC row before ordinary
+// output is printed or control returns to the host. Clearing lines leaves
+// the row open.
+func (vm *VM) endColumnRow() {
+	if vm.printCCounter > 0 {
+		vm.printCCounter = 0
+		vm.writeOutput(Output{NewLine: true})
+	}
+}
+
 func (vm *VM) waitAfterPrint() error {
 	req := InputRequest{Command: "WAITANYKEY", Numeric: false, OneInput: false, Timed: false, Nullable: false, HasDefault: false}
 	_, _, err := vm.resolveInput(req)
@@ -5529,6 +7129,50 @@ func isResultLikeName(name string) bool {
 	return name == "RESULT" || name == "RESULTS"
 }
 
+// resultArray returns the single global store backing RESULT or RESULTS.
+// Local declarations and argument slots never shadow it, so a value
+// written in a callee is what the caller reads after CALL returns.
+func (vm *VM) resultArray(name string) *ArrayVar {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	arr := vm.gArrays[name]
+	if arr == nil {
+		arr = newArrayVar(name == "RESULTS", true, []int{1})
+		vm.gArrays[name] = arr
+	}
+	return arr
+}
+
+func (vm *VM) setResultVar(name string, v Value) {
+	_ = vm.setResultAt(name, []int64{0}, v)
+}
+
+// setResultAt stores v without coercing it to the array kind; built-in
+// commands have always been free to leave a string in RESULT.
+func (vm *VM) setResultAt(name string, index []int64, v Value) error {
+	arr := vm.resultArray(name)
+	k, err := arr.key(index)
+	if err != nil {
+		return fmt.Errorf("%s:%v: %w", name, index, err)
+	}
+	arr.Data[k] = v
+	return nil
+}
+
+func (vm *VM) getResultVar(name string, index []int64) (Value, error) {
+	arr := vm.resultArray(name)
+	if len(index) == 0 {
+		index = []int64{0}
+	}
+	v, err := arr.Get(index)
+	if err != nil {
+		if hasNegativeIndex(index) {
+			return arr.defaultValue(), nil
+		}
+		return Value{}, fmt.Errorf("%s:%v: %w", name, index, err)
+	}
+	return v, nil
+}
+
 func (vm *VM) setVar(name string, v Value) {
 	name = strings.ToUpper(name)
 	if bound, ok := vm.resolveRefBinding(name); ok {
@@ -5536,16 +7180,7 @@ func (vm *VM) setVar(name string, v Value) {
 		return
 	}
 	if isResultLikeName(name) {
-		vm.globals[name] = v
-		if fr := vm.currentFrame(); fr != nil {
-			if arr, ok := fr.lArrays[name]; ok {
-				_ = arr.Set([]int64{0}, v)
-				return
-			}
-		}
-		if arr, ok := vm.gArrays[name]; ok {
-			_ = arr.Set([]int64{0}, v)
-		}
+		vm.setResultVar(name, v)
 		return
 	}
 	if fr := vm.currentFrame(); fr != nil {
@@ -5575,7 +7210,7 @@ func (vm *VM) setVar(name string, v Value) {
 func (vm *VM) getVar(name string) Value {
 	name = strings.ToUpper(name)
 	if name == "LINECOUNT" {
-		return Int(int64(len(vm.outputs)))
+		return Int(vm.lineCount())
 	}
 	if bound, ok := vm.resolveRefBinding(name); ok {
 		v, err := vm.getVarRef(bound)
@@ -5584,9 +7219,8 @@ func (vm *VM) getVar(name string) Value {
 		}
 	}
 	if isResultLikeName(name) {
-		if v, ok := vm.globals[name]; ok {
-			return v
-		}
+		v, _ := vm.getResultVar(name, nil)
+		return v
 	}
 	if fr := vm.currentFrame(); fr != nil {
 		if v, ok := fr.locals[name]; ok {
@@ -5661,6 +7295,39 @@ func (vm *VM) isCharacterTextBase(name string) bool {
 	}
 }
 
+func (vm *VM) characterIndexByID(id int64) int64 {
+	for i, ch := range vm.characters {
+		if ch.ID == id {
+			return int64(i)
+		}
+	}
+	return -1
+}
+
+// setCharacterNo handles NO:i = id. When another slot already holds id the
+// two characters swap places so the roster order follows the write;
+// otherwise slot i is relabelled.
+func (vm *VM) setCharacterNo(i int, id int64) {
+	if j := int(vm.characterIndexByID(id)); j >= 0 && j != i {
+		vm.characters[i], vm.characters[j] = vm.characters[j], vm.characters[i]
+	} else {
+		vm.characters[i].ID = id
+	}
+	if vm.gArrays["NO"] != nil {
+		vm.syncNoArray()
+	}
+}
+
+// syncNoArray materialises NO as a plain array of the current roster IDs,
+// for array builtins that read storage directly instead of via NO:i.
+func (vm *VM) syncNoArray() {
+	arr := newArrayVar(false, false, []int{max(1, len(vm.characters))})
+	for i, ch := range vm.characters {
+		_ = arr.Set([]int64{int64(i)}, Int(ch.ID))
+	}
+	vm.gArrays["NO"] = arr
+}
+
 func (vm *VM) characterIDByIndex(index int64) (int64, bool) {
 	if index < 0 || index >= int64(len(vm.characters)) {
 		return 0, false
@@ -5682,6 +7349,127 @@ func (vm *VM) characterTextFallback(name string, index []int64) (Value, bool) {
 	return Str(""), true
 }
 
+// execSelectChara makes a roster slot the implicit character of references
+// like ABL:力 that leave the character index out. A negative or missing
+// slot clears the selection; RESULT is the previous selection. The
+// selection follows its character through DELCHARA, SWAPCHARA and
+// SORTCHARA, and is cleared when that character is deleted.
+func (vm *VM) execSelectChara(arg string) (execResult, error) {
+	prev := vm.selectedChara
+	slot := int64(-1)
+	if strings.TrimSpace(arg) != "" {
+		v, err := vm.evalLooseExpr(arg)
+		if err != nil {
+			return execResult{}, err
+		}
+		slot = v.Int64()
+	}
+	if slot >= int64(len(vm.characters)) {
+		return execResult{}, fmt.Errorf("SELECTCHARA: character %d out of range (count %d)", slot, len(vm.characters))
+	}
+	if slot < 0 {
+		slot = -1
+	}
+	vm.selectedChara = int(slot)
+	vm.setResultVar("RESULT", Int(int64(prev)))
+	return execResult{kind: resultNone}, nil
+}
+
+// withSelectedChara prepends the SELECTCHARA slot to a single-index
+// reference to a character array, so ABL:力 reads ABL:<selected>:力.
+func (vm *VM) withSelectedChara(name string, index []int64) []int64 {
+	if len(index) != 1 || vm.selectedChara < 0 || vm.selectedChara >= len(vm.characters) {
+		return index
+	}
+	if vm.isCharacterTextBase(name) || !slices.Contains(characterArrayBases, name) {
+		return index
+	}
+	return []int64{int64(vm.selectedChara), index[0]}
+}
+
+// charaDataKey finds the Vars key GETCHARADATA and SETCHARADATA address by
+// name, matched without regard to case like the other CSV-name lookups.
+func charaDataKey(vars map[string]Value, name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if _, ok := vars[name]; ok {
+		return name, true
+	}
+	for key := range vars {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// charaDataValue coerces a SETCHARADATA value to the type of the Vars slot
+// key: that of the array for character array elements such as CFLAG:3 or
+// NAME, that of the stored value for other existing keys, and as given for
+// new ones.
+func (vm *VM) charaDataValue(vars map[string]Value, key string, v Value) Value {
+	base, _, _ := strings.Cut(key, ":")
+	isString := v.Kind() == StringKind
+	if slices.Contains(characterArrayBases, base) {
+		isString = vm.isStringArrayBase(base)
+	} else if old, ok := vars[key]; ok {
+		isString = old.Kind() == StringKind
+	}
+	if isString {
+		return Str(v.String())
+	}
+	return numericValue(v)
+}
+
+// characterVarSlot maps a two-or-more index reference to a character array
+// (CFLAG:0:10) onto the roster slot and the Vars key ("CFLAG:10") it lives
+// under. References whose first index is not a current slot are left to the
+// plain global arrays.
+func (vm *VM) characterVarSlot(name string, index []int64) (int, string, bool) {
+	if len(index) < 2 || vm.isCharacterTextBase(name) || !slices.Contains(characterArrayBases, name) {
+		return 0, "", false
+	}
+	if index[0] < 0 || index[0] >= int64(len(vm.characters)) {
+		return 0, "", false
+	}
+	parts := make([]string, len(index))
+	parts[0] = name
+	for i, n := range index[1:] {
+		parts[i+1] = strconv.FormatInt(n, 10)
+	}
+	return int(index[0]), strings.Join(parts, ":"), true
+}
+
+// characterVarValue reads a character variable, falling back to
+// characterVarFallback when no value is stored.
+func (vm *VM) characterVarValue(name string, slot int, key string, index []int64) Value {
+	ch := vm.characters[slot]
+	if v, ok := ch.Vars[key]; ok {
+		return v
+	}
+	return vm.characterVarFallback(name, ch, index[1:])
+}
+
+// characterVarFallback is what element sub of ch's character array name
+// reads while unset: the character's CSV row, unless it is void, and then
+// the zero value of the array kind.
+func (vm *VM) characterVarFallback(name string, ch RuntimeCharacter, sub []int64) Value {
+	isString := vm.isStringArrayBase(name)
+	if len(sub) == 1 && !ch.void {
+		if raw, ok := vm.csv.CharaField(ch.ID, csvBaseFromVarName(name), strconv.FormatInt(sub[0], 10)); ok {
+			if isString {
+				return Str(raw)
+			}
+			if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+				return Int(n)
+			}
+		}
+	}
+	if isString {
+		return Str("")
+	}
+	return Int(0)
+}
+
 func arrayHasExplicitValue(arr *ArrayVar, index []int64) bool {
 	if arr == nil {
 		return false
@@ -5856,16 +7644,23 @@ func (vm *VM) getVarRef(ref ast.VarRef) (Value, error) {
 		if n <= 0 {
 			return Int(0), nil
 		}
-		return Int(vm.rng.Int63n(n)), nil
+		return Int(vm.randN(n)), nil
+	}
+	if key, ok := vm.namedIndexKey(name, ref.Index); ok {
+		if arr := vm.namedKeyArray(name, false); arr != nil {
+			return arr.GetKey(key), nil
+		}
+		if vm.isStringArrayBase(name) {
+			return Str(""), nil
+		}
+		return Int(0), nil
 	}
 	index, err := vm.evalIndexExprsFor(name, ref.Index)
 	if err != nil {
 		return Value{}, err
 	}
-	if isResultLikeName(name) && len(index) == 1 && index[0] == 0 {
-		if v, ok := vm.globals[name]; ok {
-			return v, nil
-		}
+	if isResultLikeName(name) {
+		return vm.getResultVar(name, index)
 	}
 	if name == "NO" && len(index) > 0 {
 		if id, ok := vm.characterIDByIndex(index[0]); ok {
@@ -5898,6 +7693,10 @@ func (vm *VM) getVarRef(ref ast.VarRef) (Value, error) {
 			return v, nil
 		}
 	}
+	charIndex := vm.withSelectedChara(name, index)
+	if slot, key, ok := vm.characterVarSlot(name, charIndex); ok {
+		return vm.characterVarValue(name, slot, key, charIndex), nil
+	}
 	if arr, ok := vm.gArrays[name]; ok {
 		v, err := arr.Get(index)
 		if err != nil {
@@ -5950,22 +7749,26 @@ func (vm *VM) setVarRef(ref ast.VarRef, v Value) error {
 		vm.setVar(name, v)
 		return nil
 	}
+	if key, ok := vm.namedIndexKey(name, ref.Index); ok {
+		vm.namedKeyArray(name, true).SetKey(key, v)
+		return nil
+	}
 	index, err := vm.evalIndexExprsFor(name, ref.Index)
 	if err != nil {
 		return err
 	}
 	if name == "NO" && len(index) > 0 && index[0] >= 0 && index[0] < int64(len(vm.characters)) {
-		vm.characters[int(index[0])].ID = v.Int64()
+		vm.setCharacterNo(int(index[0]), v.Int64())
 		return nil
 	}
+	if isResultLikeName(name) {
+		return vm.setResultAt(name, index, v)
+	}
 	if fr := vm.currentFrame(); fr != nil {
 		if arr, ok := fr.lArrays[name]; ok {
 			if err := arr.Set(index, v); err != nil {
 				return fmt.Errorf("%s:%v: %w", name, index, err)
 			}
-			if isResultLikeName(name) && len(index) == 1 && index[0] == 0 {
-				vm.globals[name] = v
-			}
 			return nil
 		}
 		// LOCAL-prefixed variables should always be local arrays
@@ -5978,6 +7781,18 @@ func (vm *VM) setVarRef(ref ast.VarRef, v Value) error {
 			return nil
 		}
 	}
+	if slot, key, ok := vm.characterVarSlot(name, vm.withSelectedChara(name, index)); ok {
+		ch := &vm.characters[slot]
+		if ch.Vars == nil {
+			ch.Vars = map[string]Value{}
+		}
+		if vm.isStringArrayBase(name) {
+			ch.Vars[key] = Str(v.String())
+		} else {
+			ch.Vars[key] = numericValue(v)
+		}
+		return nil
+	}
 	arr := vm.gArrays[name]
 	if arr == nil {
 		arr = newArrayVar(vm.isStringArrayBase(name), true, dimsForIndex(index))
@@ -5986,9 +7801,6 @@ func (vm *VM) setVarRef(ref ast.VarRef, v Value) error {
 	if err := arr.Set(index, v); err != nil {
 		return fmt.Errorf("%s:%v: %w", name, index, err)
 	}
-	if isResultLikeName(name) && len(index) == 1 && index[0] == 0 {
-		vm.globals[name] = v
-	}
 	return nil
 }
 
@@ -6131,6 +7943,50 @@ func (vm *VM) evalIndexExprsFor(baseName string, exprs []ast.Expr) ([]int64, err
 	return idx, nil
 }
 
+// namedIndexKey returns the literal key of a quoted single index such as
+// FLAG:"quest_started" when it names no CSV entry of the array's table, so
+// the element is stored under that string instead of collapsing to 0.
+func (vm *VM) namedIndexKey(name string, exprs []ast.Expr) (string, bool) {
+	if len(exprs) != 1 || isResultLikeName(name) || name == "NO" {
+		return "", false
+	}
+	if _, ok := csvNameVarTargetBase(name); ok {
+		return "", false
+	}
+	lit, ok := exprs[0].(ast.StringLit)
+	if !ok || !isNamedKey(lit.Value) {
+		return "", false
+	}
+	if _, ok := vm.resolveNamedCSVIndexValue(csvBaseFromVarName(name), Str(lit.Value)); ok {
+		return "", false
+	}
+	return lit.Value, true
+}
+
+// namedKeyArray finds the array a string-keyed reference addresses,
+// creating it like an indexed assignment would when create is set.
+func (vm *VM) namedKeyArray(name string, create bool) *ArrayVar {
+	fr := vm.currentFrame()
+	if fr != nil {
+		if arr, ok := fr.lArrays[name]; ok {
+			return arr
+		}
+	}
+	if arr, ok := vm.gArrays[name]; ok {
+		return arr
+	}
+	if !create {
+		return nil
+	}
+	arr := newArrayVar(vm.isStringArrayBase(name), true, nil)
+	if fr != nil && strings.HasPrefix(name, "LOCAL") {
+		fr.lArrays[name] = arr
+	} else {
+		vm.gArrays[name] = arr
+	}
+	return arr
+}
+
 func (vm *VM) resolveNamedCSVIndex(baseName string, expr ast.Expr) (int64, bool) {
 	baseName = strings.ToUpper(strings.TrimSpace(baseName))
 	if baseName == "" {
@@ -6153,6 +8009,72 @@ func (vm *VM) resolveNamedCSVIndex(baseName string, expr ast.Expr) (int64, bool)
 	return 0, false
 }
 
+// resolveCSVConstant lets a bare identifier that is not a known variable
+// stand for the ID of a CSV entry with that name, e.g. an ability name used
+// as a value. The name must map to a single ID across all tables, and names
+// the program assigns anywhere or the runtime reserves never resolve, so an
+// implicit variable read before its first assignment still reads 0.
+func (vm *VM) resolveCSVConstant(ref ast.VarRef) (int64, bool) {
+	if len(ref.Index) != 0 {
+		return 0, false
+	}
+	id, ok := vm.csv.FindAnyID(ref.Name)
+	if !ok {
+		return 0, false
+	}
+	name := strings.ToUpper(strings.TrimSpace(ref.Name))
+	if vm.assignedNames[name] || isReservedVarName(name) || vm.symbolExists(name) {
+		return 0, false
+	}
+	return id, true
+}
+
+// isReservedVarName reports names the runtime answers itself on a bare read.
+func isReservedVarName(name string) bool {
+	if name == "LINECOUNT" || isResultLikeName(name) {
+		return true
+	}
+	return slices.Contains(characterArrayBases, name)
+}
+
+// collectAssignedNames records every variable t assigns, increments,
+// fills with STRDATA or uses as a FOR counter, nested blocks included.
+func collectAssignedNames(t *ast.Thunk, into map[string]bool) {
+	if t == nil {
+		return
+	}
+	for _, stmt := range t.Statements {
+		switch s := stmt.(type) {
+		case ast.AssignStmt:
+			into[strings.ToUpper(s.Target.Name)] = true
+		case ast.IncDecStmt:
+			into[strings.ToUpper(s.Target.Name)] = true
+		case ast.StrDataStmt:
+			into[strings.ToUpper(s.Target.Name)] = true
+		case ast.IfStmt:
+			for _, b := range s.Branches {
+				collectAssignedNames(b.Body, into)
+			}
+			collectAssignedNames(s.Else, into)
+		case ast.SelectCaseStmt:
+			for _, b := range s.Branches {
+				collectAssignedNames(b.Body, into)
+			}
+			collectAssignedNames(s.Else, into)
+		case ast.WhileStmt:
+			collectAssignedNames(s.Body, into)
+		case ast.DoWhileStmt:
+			collectAssignedNames(s.Body, into)
+		case ast.RepeatStmt:
+			collectAssignedNames(s.Body, into)
+		case ast.ForStmt:
+			into[strings.ToUpper(s.Var)] = true
+			into[strings.ToUpper(s.Target.Name)] = true
+			collectAssignedNames(s.Body, into)
+		}
+	}
+}
+
 func (vm *VM) resolveNamedCSVIndexValue(csvBase string, v Value) (int64, bool) {
 	csvBase = strings.ToUpper(strings.TrimSpace(csvBase))
 	if csvBase == "" || v.Kind() != StringKind {
@@ -6208,14 +8130,64 @@ func (vm *VM) symbolExists(name string) bool {
 	return false
 }
 
+// varKind classifies a variable name for VARKIND: 0 missing, 1 integer
+// scalar, 2 string scalar, 3 integer array, 4 string array.
+func (vm *VM) varKind(name string) int64 {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "" {
+		return 0
+	}
+	if bound, ok := vm.resolveRefBinding(name); ok && strings.ToUpper(bound.Name) != name {
+		return vm.varKind(bound.Name)
+	}
+	scalar := func(v Value) int64 {
+		if v.Kind() == StringKind {
+			return 2
+		}
+		return 1
+	}
+	array := func(arr *ArrayVar) int64 {
+		if arr.IsString {
+			return 4
+		}
+		return 3
+	}
+	if fr := vm.currentFrame(); fr != nil {
+		if v, ok := fr.locals[name]; ok {
+			return scalar(v)
+		}
+		if arr, ok := fr.lArrays[name]; ok {
+			return array(arr)
+		}
+	}
+	if v, ok := vm.globals[name]; ok {
+		if _, isStr := vm.program.StringVars[name]; isStr {
+			return 2
+		}
+		return scalar(v)
+	}
+	if arr, ok := vm.gArrays[name]; ok {
+		return array(arr)
+	}
+	if _, ok := vm.program.StringVars[name]; ok {
+		return 2
+	}
+	if vm.symbolExists(name) {
+		return 1
+	}
+	return 0
+}
+
 func (vm *VM) storeResult(values []Value) {
 	if len(values) == 0 {
-		vm.globals["RESULT"] = Int(0)
+		vm.setResultVar("RESULT", Int(0))
 		return
 	}
-	vm.globals["RESULT"] = values[0]
 	for i, v := range values {
-		vm.globals[fmt.Sprintf("RESULT%d", i)] = v
+		_ = vm.setResultAt("RESULT", []int64{int64(i)}, v)
+		if v.Kind() == StringKind {
+			_ = vm.setResultAt("RESULTS", []int64{int64(i)}, v)
+		}
 	}
 }
 
@@ -6301,6 +8273,9 @@ func htmlSubstring(html string, start int64, length int64) string {
 }
 
 func (vm *VM) resolveVarByName(name string, indices []Value) (Value, error) {
+	if ref, ok := vm.varRefByName(name, indices); ok {
+		return vm.getVarRef(ref)
+	}
 	name = strings.ToUpper(strings.TrimSpace(name))
 	idx := make([]int64, len(indices))
 	for i, v := range indices {
@@ -6332,6 +8307,9 @@ func (vm *VM) resolveVarByName(name string, indices []Value) (Value, error) {
 }
 
 func (vm *VM) setVarByName(name string, indices []Value, value Value) error {
+	if ref, ok := vm.varRefByName(name, indices); ok {
+		return vm.setVarRef(ref, value)
+	}
 	name = strings.ToUpper(strings.TrimSpace(name))
 	idx := make([]int64, len(indices))
 	for i, v := range indices {
@@ -6351,6 +8329,24 @@ func (vm *VM) setVarByName(name string, indices []Value, value Value) error {
 	return fmt.Errorf("variable not found: %s", name)
 }
 
+// varRefByName parses a GETVAR/SETVAR name that carries its own indices,
+// such as "ABL:0:STR", so CSV names and character stats resolve the way a
+// written reference would. Index arguments are appended after the embedded
+// ones.
+func (vm *VM) varRefByName(name string, indices []Value) (ast.VarRef, bool) {
+	if !strings.Contains(name, ":") {
+		return ast.VarRef{}, false
+	}
+	ref, err := vm.parseVarRefRuntime(name)
+	if err != nil || len(ref.Index) == 0 {
+		return ast.VarRef{}, false
+	}
+	for _, v := range indices {
+		ref.Index = append(ref.Index, ast.IntLit{Value: v.Int64()})
+	}
+	return ref, true
+}
+
 func (vm *VM) enumFunctions(args []Value, mode string) Value {
 	if len(args) < 1 {
 		return Str("")
@@ -6374,8 +8370,7 @@ func (vm *VM) enumFunctions(args []Value, mode string) Value {
 			}
 		}
 	}
-	sort.Strings(names)
-	return Str(strings.Join(names, ":"))
+	return Str(strings.Join(sortedUniqueNames(names), ":"))
 }
 
 func (vm *VM) enumVariables(args []Value, mode string) Value {
@@ -6414,8 +8409,21 @@ func (vm *VM) enumVariables(args []Value, mode string) Value {
 	for name := range vm.gArrays {
 		addVar(name)
 	}
+	return Str(strings.Join(sortedUniqueNames(names), ":"))
+}
+
+// sortedUniqueNames orders enumeration results alphabetically and drops
+// case-insensitive duplicates so scripts get a stable list.
+func sortedUniqueNames(names []string) []string {
 	sort.Strings(names)
-	return Str(strings.Join(names, ":"))
+	out := names[:0]
+	for i, name := range names {
+		if i > 0 && strings.EqualFold(name, out[len(out)-1]) {
+			continue
+		}
+		out = append(out, name)
+	}
+	return out
 }
 
 func (vm *VM) enumMacros(args []Value, mode string) Value {
@@ -6441,8 +8449,7 @@ func (vm *VM) enumMacros(args []Value, mode string) Value {
 			}
 		}
 	}
-	sort.Strings(names)
-	return Str(strings.Join(names, ":"))
+	return Str(strings.Join(sortedUniqueNames(names), ":"))
 }
 
 func (vm *VM) execPrintCharaData(name, arg string) (execResult, error) {
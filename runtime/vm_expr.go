@@ -2,6 +2,7 @@ package eruntime
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
@@ -12,9 +13,14 @@ func (vm *VM) evalExpr(e ast.Expr) (Value, error) {
 	switch ex := e.(type) {
 	case ast.IntLit:
 		return Int(ex.Value), nil
+	case ast.FloatLit:
+		return Float(ex.Value), nil
 	case ast.StringLit:
 		return Str(ex.Value), nil
 	case ast.VarRef:
+		if id, ok := vm.resolveCSVConstant(ex); ok {
+			return Int(id), nil
+		}
 		return vm.getVarRef(ex)
 	case ast.UnaryExpr:
 		v, err := vm.evalExpr(ex.Expr)
@@ -23,8 +29,14 @@ func (vm *VM) evalExpr(e ast.Expr) (Value, error) {
 		}
 		switch ex.Op {
 		case "+":
+			if v.Kind() == FloatKind {
+				return v, nil
+			}
 			return Int(v.Int64()), nil
 		case "-":
+			if v.Kind() == FloatKind {
+				return Float(-v.Float64()), nil
+			}
 			return Int(-v.Int64()), nil
 		case "!":
 			if v.Truthy() {
@@ -169,7 +181,7 @@ func (vm *VM) evalCallExpr(ex ast.CallExpr) (Value, error) {
 			}
 			return Value{}, err
 		}
-		return vm.getVar("RESULT"), nil
+		return vm.userCallResult(), nil
 	}
 	if v, handled, err := vm.execMethodLike(name, rawExprArg); handled {
 		return v, err
@@ -184,6 +196,17 @@ func (vm *VM) evalCallExpr(ex ast.CallExpr) (Value, error) {
 	return Value{}, fmt.Errorf("unknown expression call %s", name)
 }
 
+// userCallResult picks the value a user function call evaluates to:
+// RESULTS when the function returned a string, RESULT otherwise.
+func (vm *VM) userCallResult() Value {
+	if v, err := vm.getResultVar("RESULT", nil); err == nil && v.Kind() == StringKind {
+		if s, err := vm.getResultVar("RESULTS", nil); err == nil {
+			return s
+		}
+	}
+	return vm.getVar("RESULT")
+}
+
 func (vm *VM) evalCallExprArgs(exprs []ast.Expr) ([]Value, []bool, error) {
 	args := make([]Value, 0, len(exprs))
 	missing := make([]bool, 0, len(exprs))
@@ -256,6 +279,8 @@ func exprToSource(e ast.Expr) string {
 	switch ex := e.(type) {
 	case ast.IntLit:
 		return strconv.FormatInt(ex.Value, 10)
+	case ast.FloatLit:
+		return Float(ex.Value).String()
 	case ast.StringLit:
 		return strconv.Quote(ex.Value)
 	case ast.VarRef:
@@ -287,7 +312,62 @@ func exprToSource(e ast.Expr) string {
 	}
 }
 
+// isFloatOperation reports whether a binary operation runs in floating
+// point: at least one side is a float and neither side is a string.
+func isFloatOperation(left, right Value) bool {
+	if left.Kind() == StringKind || right.Kind() == StringKind {
+		return false
+	}
+	return left.Kind() == FloatKind || right.Kind() == FloatKind
+}
+
+func evalFloatBinary(op string, l, r float64) (Value, bool) {
+	cmp := func(ok bool) (Value, bool) {
+		if ok {
+			return Int(1), true
+		}
+		return Int(0), true
+	}
+	switch op {
+	case "+":
+		return Float(l + r), true
+	case "-":
+		return Float(l - r), true
+	case "*":
+		return Float(l * r), true
+	case "/":
+		if r == 0 {
+			// Same permissive x/0 == x/1 fallback as the integer path.
+			return Float(l), true
+		}
+		return Float(l / r), true
+	case "%":
+		if r == 0 {
+			return Float(0), true
+		}
+		return Float(math.Mod(l, r)), true
+	case "==":
+		return cmp(l == r)
+	case "!=":
+		return cmp(l != r)
+	case "<":
+		return cmp(l < r)
+	case "<=":
+		return cmp(l <= r)
+	case ">":
+		return cmp(l > r)
+	case ">=":
+		return cmp(l >= r)
+	}
+	return Value{}, false
+}
+
 func evalBinary(op string, left, right Value) (Value, error) {
+	if isFloatOperation(left, right) {
+		if v, ok := evalFloatBinary(op, left.Float64(), right.Float64()); ok {
+			return v, nil
+		}
+	}
 	switch op {
 	case "+":
 		if left.Kind() == StringKind || right.Kind() == StringKind {